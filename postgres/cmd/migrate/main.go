@@ -0,0 +1,322 @@
+// Command migrate applies and manages the migrations of a directory.
+//
+// Usage:
+//
+//	migrate [-dsn url] [-dir path] [-table name] [-schema name] [-phase expand|contract] <command> [arguments]
+//
+// The commands are:
+//
+//	up [-to version]               apply the pending migrations
+//	down [-steps n]                revert the latest applied migrations
+//	status [-format table|json]    print the status of the migrations
+//	create [-timestamp] [-down] <name>  create a new migration file
+//	baseline <version>             record the migrations up to version as applied
+//	lint                           report risky statements on the migrations
+//
+// The DSN defaults to the DATABASE_URL environment variable.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/crossworth/pkgs/postgres/migrations"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const usage = `usage: migrate [-dsn url] [-dir path] [-table name] [-schema name] [-phase expand|contract] <command> [arguments]
+
+commands:
+  up [-to version]                    apply the pending migrations
+  down [-steps n]                     revert the latest applied migrations
+  status [-format table|json]         print the status of the migrations
+  create [-timestamp] [-down] <name>  create a new migration file
+  baseline <version>                  record the migrations up to version as applied
+  lint                                report risky statements on the migrations
+`
+
+// errUsage is returned for invalid arguments, after the usage is printed.
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, errUsage) {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+}
+
+// config is the configuration shared by the commands.
+type config struct {
+	dsn    string
+	dir    string
+	table  string
+	schema string
+	phase  string
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// options returns the migration options for the configuration.
+func (c *config) options() []migrations.Option {
+	var opts []migrations.Option
+	if c.table != "" {
+		opts = append(opts, migrations.WithTableName(c.table))
+	}
+	if c.schema != "" {
+		opts = append(opts, migrations.WithSchema(c.schema))
+	}
+	if c.phase != "" {
+		opts = append(opts, migrations.WithPhase(migrations.Phase(c.phase)))
+	}
+	return opts
+}
+
+// migrationsDir returns the migrations directory.
+func (c *config) migrationsDir() fs.FS {
+	return os.DirFS(c.dir)
+}
+
+// connect creates a connection pool to the database.
+func (c *config) connect(ctx context.Context) (*pgxpool.Pool, error) {
+	if c.dsn == "" {
+		return nil, fmt.Errorf("migrate: missing database url, use -dsn or DATABASE_URL")
+	}
+	pool, err := pgxpool.New(ctx, c.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("migrate: connecting to database: %w", err)
+	}
+	return pool, nil
+}
+
+// run parses the arguments and executes the command.
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) error {
+	cfg := &config{stdout: stdout, stderr: stderr}
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { _, _ = fmt.Fprint(stderr, usage) }
+	flags.StringVar(&cfg.dsn, "dsn", os.Getenv("DATABASE_URL"), "database url")
+	flags.StringVar(&cfg.dir, "dir", "migrations", "migrations directory")
+	flags.StringVar(&cfg.table, "table", "", "migrations table name")
+	flags.StringVar(&cfg.schema, "schema", "", "migrations table schema")
+	flags.StringVar(&cfg.phase, "phase", "", "deploy phase, expand or contract")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errUsage
+	}
+	if cfg.phase != "" {
+		if _, err := migrations.ParsePhase(cfg.phase); err != nil {
+			return fmt.Errorf("migrate: %w", err)
+		}
+	}
+	commands := map[string]func(ctx context.Context, cfg *config, args []string) error{
+		"up":       up,
+		"down":     down,
+		"status":   status,
+		"create":   create,
+		"baseline": baseline,
+		"lint":     lint,
+	}
+	command, ok := commands[flags.Arg(0)]
+	if !ok {
+		_, _ = fmt.Fprintf(stderr, "migrate: unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		return errUsage
+	}
+	return command(ctx, cfg, flags.Args()[1:])
+}
+
+// parseCommand parses the command flags, failing for unexpected positional arguments.
+func parseCommand(cfg *config, flags *flag.FlagSet, args []string, nargs int) error {
+	flags.SetOutput(cfg.stderr)
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != nargs {
+		_, _ = fmt.Fprint(cfg.stderr, usage)
+		return errUsage
+	}
+	return nil
+}
+
+func up(ctx context.Context, cfg *config, args []string) error {
+	flags := flag.NewFlagSet("up", flag.ContinueOnError)
+	to := flags.Int64("to", math.MaxInt64, "target version")
+	if err := parseCommand(cfg, flags, args, 0); err != nil {
+		return err
+	}
+	pool, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	return migrations.MigrateTo(ctx, pool, cfg.migrationsDir(), *to, cfg.options()...)
+}
+
+func down(ctx context.Context, cfg *config, args []string) error {
+	flags := flag.NewFlagSet("down", flag.ContinueOnError)
+	steps := flags.Int("steps", 1, "number of migrations to revert")
+	if err := parseCommand(cfg, flags, args, 0); err != nil {
+		return err
+	}
+	pool, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	return migrations.Rollback(ctx, pool, cfg.migrationsDir(), *steps, cfg.options()...)
+}
+
+// statusEntry is the JSON representation of a migration status.
+type statusEntry struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	Phase     string     `json:"phase,omitempty"`
+	State     string     `json:"state"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+func status(ctx context.Context, cfg *config, args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	format := flags.String("format", "table", "output format, table or json")
+	if err := parseCommand(cfg, flags, args, 0); err != nil {
+		return err
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("migrate: unknown format %q", *format)
+	}
+	pool, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	statuses, err := migrations.Status(ctx, pool, cfg.migrationsDir(), cfg.options()...)
+	if err != nil {
+		return err
+	}
+	return writeStatus(cfg.stdout, statuses, *format)
+}
+
+// writeStatus writes the statuses on the given format.
+func writeStatus(w io.Writer, statuses []migrations.MigrationStatus, format string) error {
+	if format == "json" {
+		entries := make([]statusEntry, 0, len(statuses))
+		for _, s := range statuses {
+			entries = append(entries, statusEntry{
+				Version:   s.Definition.Version,
+				Name:      s.Definition.Name,
+				Phase:     string(s.Definition.Phase),
+				State:     string(s.State),
+				AppliedAt: s.AppliedAt,
+			})
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "VERSION\tNAME\tPHASE\tSTATE\tAPPLIED AT")
+	for _, s := range statuses {
+		appliedAt := "-"
+		if s.AppliedAt != nil {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+		version := "-"
+		if s.Definition.Version != 0 {
+			version = strconv.FormatInt(s.Definition.Version, 10)
+		}
+		phase := "-"
+		if s.Definition.Phase != "" {
+			phase = string(s.Definition.Phase)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", version, s.Definition.Name, phase, s.State, appliedAt)
+	}
+	return tw.Flush()
+}
+
+func create(_ context.Context, cfg *config, args []string) error {
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	timestamp := flags.Bool("timestamp", false, "use the current time as version")
+	withDown := flags.Bool("down", false, "also create the down migration")
+	if err := parseCommand(cfg, flags, args, 1); err != nil {
+		return err
+	}
+	name, err := migrations.NewMigrationName(cfg.migrationsDir(), flags.Arg(0), *timestamp, time.Now())
+	if err != nil {
+		return err
+	}
+	names := []string{name}
+	if *withDown {
+		names = append(names, name[:len(name)-len(".sql")]+".down.sql")
+	}
+	for _, n := range names {
+		path := filepath.Join(cfg.dir, n)
+		// O_EXCL so an existing migration is never overwritten
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return fmt.Errorf("migrate: creating migration: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("migrate: creating migration: %w", err)
+		}
+		_, _ = fmt.Fprintln(cfg.stdout, path)
+	}
+	return nil
+}
+
+func baseline(ctx context.Context, cfg *config, args []string) error {
+	flags := flag.NewFlagSet("baseline", flag.ContinueOnError)
+	if err := parseCommand(cfg, flags, args, 1); err != nil {
+		return err
+	}
+	version, err := strconv.ParseInt(flags.Arg(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("migrate: invalid version %q", flags.Arg(0))
+	}
+	pool, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	return migrations.Baseline(ctx, pool, cfg.migrationsDir(), version, cfg.options()...)
+}
+
+func lint(_ context.Context, cfg *config, args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	if err := parseCommand(cfg, flags, args, 0); err != nil {
+		return err
+	}
+	issues, err := migrations.Lint(cfg.migrationsDir(), cfg.options()...)
+	if err != nil {
+		return err
+	}
+	var errorsFound int
+	for _, issue := range issues {
+		_, _ = fmt.Fprintln(cfg.stdout, issue)
+		if issue.Severity == migrations.SeverityError {
+			errorsFound++
+		}
+	}
+	if errorsFound > 0 {
+		return fmt.Errorf("migrate: %d lint errors found", errorsFound)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/crossworth/pkgs/postgres/migrations"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	t.Run("usage", func(t *testing.T) {
+		t.Parallel()
+		var stdout, stderr bytes.Buffer
+		require.ErrorIs(t, run(context.Background(), nil, &stdout, &stderr), errUsage)
+		require.Contains(t, stderr.String(), "usage: migrate")
+		require.ErrorIs(t, run(context.Background(), []string{"unknown"}, &stdout, &stderr), errUsage)
+		require.Contains(t, stderr.String(), `unknown command "unknown"`)
+		require.ErrorIs(t, run(context.Background(), []string{"create"}, &stdout, &stderr), errUsage)
+	})
+	t.Run("missing dsn", func(t *testing.T) {
+		t.Parallel()
+		var stdout, stderr bytes.Buffer
+		err := run(context.Background(), []string{"-dsn", "", "up"}, &stdout, &stderr)
+		require.ErrorContains(t, err, "missing database url")
+	})
+	t.Run("invalid phase", func(t *testing.T) {
+		t.Parallel()
+		var stdout, stderr bytes.Buffer
+		err := run(context.Background(), []string{"-dsn", "postgres://localhost", "-phase", "contrct", "up"}, &stdout, &stderr)
+		require.ErrorContains(t, err, `invalid phase "contrct"`)
+	})
+	t.Run("create", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "001_init.sql"), nil, 0o644))
+		var stdout, stderr bytes.Buffer
+		err := run(context.Background(), []string{"-dir", dir, "create", "-down", "add users"}, &stdout, &stderr)
+		require.NoError(t, err)
+		require.FileExists(t, filepath.Join(dir, "002_add_users.sql"))
+		require.FileExists(t, filepath.Join(dir, "002_add_users.down.sql"))
+		require.Contains(t, stdout.String(), "002_add_users.sql")
+	})
+	t.Run("lint", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "001.sql"), []byte("CREATE INDEX CONCURRENTLY users_name_idx ON users (name);"), 0o644))
+		var stdout, stderr bytes.Buffer
+		err := run(context.Background(), []string{"-dir", dir, "lint"}, &stdout, &stderr)
+		require.ErrorContains(t, err, "lint errors found")
+		require.Contains(t, stdout.String(), migrations.RuleConcurrentlyInTransaction)
+	})
+}
+
+func Test_writeStatus(t *testing.T) {
+	t.Parallel()
+	appliedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	statuses := []migrations.MigrationStatus{
+		{Definition: migrations.MigrationDefinition{Name: "001.sql", Version: 1}, State: migrations.StateApplied, AppliedAt: &appliedAt},
+		{Definition: migrations.MigrationDefinition{Name: "002.sql", Version: 2, Phase: migrations.PhaseContract}, State: migrations.StatePending},
+	}
+	var table bytes.Buffer
+	require.NoError(t, writeStatus(&table, statuses, "table"))
+	require.Equal(t, `VERSION  NAME     PHASE     STATE    APPLIED AT
+1        001.sql  -         applied  2024-03-01T12:00:00Z
+2        002.sql  contract  pending  -
+`, table.String())
+	var json bytes.Buffer
+	require.NoError(t, writeStatus(&json, statuses, "json"))
+	require.JSONEq(t, `[
+		{"version": 1, "name": "001.sql", "state": "applied", "applied_at": "2024-03-01T12:00:00Z"},
+		{"version": 2, "name": "002.sql", "phase": "contract", "state": "pending", "applied_at": null}
+	]`, json.String())
+}
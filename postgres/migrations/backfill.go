@@ -0,0 +1,234 @@
+package migrations
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/crossworth/pkgs/postgres/xpgx"
+	"github.com/jackc/pgx/v5"
+)
+
+// defaultBackfillBatchSize is the batch size used when none is provided.
+const defaultBackfillBatchSize = 1000
+
+// Backfill is a data backfill executed in batches, registered with WithBackfills.
+// The table is split in ranges of the integer key and each range is processed
+// by a short transaction, which also stores the next range as a checkpoint,
+// so a backfill interrupted by a crash resumes from the last range processed.
+// Only the rows with a key up to the greatest key when the backfill started,
+// stored with the checkpoint, are processed, rows written after it must be handled by the application,
+// e.g. by deploying the backfill on the expand phase.
+// It's recorded on the migration table as <version>_<description>.backfill,
+// with the version padded to three digits, once every range is processed.
+type Backfill struct {
+	Version     int64
+	Description string
+	// Table is the table backfilled, optionally qualified by the schema.
+	Table string
+	// Key is the integer column used to split the table, usually the primary key.
+	Key string
+	// Batch is the statement executed for each range, with the range start
+	// as $1 (inclusive) and the range end as $2 (exclusive), e.g.
+	// UPDATE users SET email = lower(email) WHERE id >= $1 AND id < $2.
+	Batch string
+	// BatchSize is the size of each key range, 1000 by default.
+	BatchSize int64
+	// Sleep is the pause between batches, leaving room for other transactions.
+	Sleep time.Duration
+	// Phase is the deploy phase of the backfill, PhaseExpand by default.
+	Phase Phase
+}
+
+// name returns the name used to record the backfill.
+func (b Backfill) name() string {
+	if b.Description == "" {
+		return fmt.Sprintf("%03d.backfill", b.Version)
+	}
+	return fmt.Sprintf("%03d_%s.backfill", b.Version, b.Description)
+}
+
+// validate checks the required fields of the backfill.
+func (b Backfill) validate() error {
+	if b.Table == "" || b.Key == "" || b.Batch == "" {
+		return fmt.Errorf("backfill %s requires the table, key and batch", b.name())
+	}
+	if b.BatchSize < 0 {
+		return fmt.Errorf("backfill %s has an invalid batch size %d", b.name(), b.BatchSize)
+	}
+	if b.Phase != "" {
+		if _, err := ParsePhase(string(b.Phase)); err != nil {
+			return fmt.Errorf("backfill %s: %w", b.name(), err)
+		}
+	}
+	return nil
+}
+
+// definition returns the migration definition of the backfill.
+func (b Backfill) definition() MigrationDefinition {
+	b.BatchSize = cmp.Or(b.BatchSize, defaultBackfillBatchSize)
+	return MigrationDefinition{
+		Name:        b.name(),
+		Version:     b.Version,
+		Description: b.Description,
+		Content:     b.Batch,
+		Phase:       cmp.Or(b.Phase, PhaseExpand),
+		Kind:        KindBackfill,
+		Backfill:    &b,
+	}
+}
+
+// BackfillProgress is the progress of a backfill, reported after each batch.
+type BackfillProgress struct {
+	// Next is the start of the next key range.
+	Next int64
+	// MaxKey is the greatest key when the backfill started.
+	MaxKey int64
+	// Batches is the number of batches executed by this run.
+	Batches int
+	// Rows is the number of rows affected by the batches of this run.
+	Rows int64
+}
+
+// BackfillObserver is implemented by observers notified of the backfill
+// progress, see WithObserver.
+type BackfillObserver interface {
+	BackfillProgress(ctx context.Context, migration MigrationDefinition, progress BackfillProgress)
+}
+
+// createBackfillTable creates the backfill checkpoint table.
+func createBackfillTable(ctx context.Context, conn xpgx.Executable, table pgx.Identifier) error {
+	if _, err := conn.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS `+table.Sanitize()+` (
+	name CHARACTER VARYING PRIMARY KEY,
+	next_key BIGINT NOT NULL,
+	max_key BIGINT NOT NULL,
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+);`); err != nil {
+		return fmt.Errorf("creating backfill table: %w", err)
+	}
+	return nil
+}
+
+// backfillCheckpoint is the progress of a backfill stored on the checkpoint table.
+type backfillCheckpoint struct {
+	// Next is the start of the next key range.
+	Next int64
+	// MaxKey is the greatest key when the backfill started.
+	MaxKey int64
+}
+
+// readBackfillCheckpoint returns the checkpoint of the backfill,
+// nil when the backfill didn't start yet.
+func readBackfillCheckpoint(ctx context.Context, conn xpgx.Queryable, table pgx.Identifier, name string) (*backfillCheckpoint, error) {
+	rows, _ := conn.Query(ctx, `SELECT next_key, max_key FROM `+table.Sanitize()+` WHERE name = $1;`, name)
+	checkpoint, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByPos[backfillCheckpoint])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying backfill checkpoint: %w", err)
+	}
+	return checkpoint, nil
+}
+
+// saveBackfillCheckpoint stores the checkpoint of the backfill, the greatest
+// key is only stored when the backfill starts.
+func saveBackfillCheckpoint(ctx context.Context, conn xpgx.Executable, table pgx.Identifier, name string, checkpoint backfillCheckpoint) error {
+	if _, err := conn.Exec(ctx, `INSERT INTO `+table.Sanitize()+` (name, next_key, max_key) VALUES ($1, $2, $3)
+ON CONFLICT (name) DO UPDATE SET next_key = EXCLUDED.next_key, updated_at = NOW();`, name, checkpoint.Next, checkpoint.MaxKey); err != nil {
+		return fmt.Errorf("saving backfill checkpoint: %w", err)
+	}
+	return nil
+}
+
+// backfillBounds returns the smallest and greatest keys of the table,
+// nil when the table is empty.
+func backfillBounds(ctx context.Context, conn xpgx.Queryable, backfill *Backfill) (*int64, *int64, error) {
+	type bounds struct {
+		Min *int64
+		Max *int64
+	}
+	key := pgx.Identifier{backfill.Key}.Sanitize()
+	table := pgx.Identifier(strings.Split(backfill.Table, ".")).Sanitize()
+	rows, _ := conn.Query(ctx, `SELECT MIN(`+key+`)::BIGINT, MAX(`+key+`)::BIGINT FROM `+table+`;`)
+	b, err := pgx.CollectOneRow(rows, pgx.RowToStructByPos[bounds])
+	if err != nil {
+		return nil, nil, fmt.Errorf("querying backfill key range: %w", err)
+	}
+	return b.Min, b.Max, nil
+}
+
+// applyBackfill executes the backfill from the last checkpoint and records
+// it as applied, removing the checkpoint, once every range is processed.
+func applyBackfill(ctx context.Context, conn xpgx.Connection, options *options, definition MigrationDefinition) error {
+	backfill, table := definition.Backfill, options.backfillTable()
+	start := time.Now()
+	if err := createBackfillTable(ctx, conn, table); err != nil {
+		return fmt.Errorf("executing backfill: %w", err)
+	}
+	checkpoint, err := readBackfillCheckpoint(ctx, conn, table, definition.Name)
+	if err != nil {
+		return fmt.Errorf("executing backfill: %w", err)
+	}
+	if checkpoint == nil {
+		minKey, maxKey, err := backfillBounds(ctx, conn, backfill)
+		if err != nil {
+			return fmt.Errorf("executing backfill: %w", err)
+		}
+		if minKey != nil && maxKey != nil {
+			// the key range is stored before the first batch,
+			// so a resumed backfill processes the same rows
+			checkpoint = &backfillCheckpoint{Next: *minKey, MaxKey: *maxKey}
+			if err := saveBackfillCheckpoint(ctx, conn, table, definition.Name, *checkpoint); err != nil {
+				return fmt.Errorf("executing backfill: %w", err)
+			}
+		}
+	}
+	if checkpoint != nil {
+		progress := BackfillProgress{Next: checkpoint.Next, MaxKey: checkpoint.MaxKey}
+		observer, _ := options.observer.(BackfillObserver)
+		for progress.Next <= progress.MaxKey {
+			if progress.Batches > 0 && backfill.Sleep > 0 {
+				select {
+				case <-ctx.Done():
+					return fmt.Errorf("executing backfill: %w", ctx.Err())
+				case <-time.After(backfill.Sleep):
+				}
+			}
+			// the last range ends after the greatest key, so the rows
+			// written after the backfill started are not processed
+			from, to := progress.Next, min(progress.Next+backfill.BatchSize, progress.MaxKey+1)
+			if err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				tag, err := tx.Exec(ctx, backfill.Batch, from, to)
+				if err != nil {
+					return err
+				}
+				progress.Rows += tag.RowsAffected()
+				return saveBackfillCheckpoint(ctx, tx, table, definition.Name, backfillCheckpoint{Next: to, MaxKey: progress.MaxKey})
+			}); err != nil {
+				return fmt.Errorf("executing backfill batch from %d to %d: %w", from, to, err)
+			}
+			progress.Next = to
+			progress.Batches++
+			if observer != nil {
+				observer.BackfillProgress(ctx, definition, progress)
+			}
+		}
+	}
+	record := newMigrationRecord(definition, options)
+	duration := time.Since(start)
+	record.Duration = &duration
+	if err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM `+table.Sanitize()+` WHERE name = $1;`, definition.Name); err != nil {
+			return fmt.Errorf("deleting backfill checkpoint: %w", err)
+		}
+		return insertMigrationRecord(ctx, tx, options.table(), record)
+	}); err != nil {
+		return fmt.Errorf("executing backfill: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/crossworth/pkgs/postgres/xpgx"
+)
+
+// Baseline records every migration up to the given version as applied,
+// without running them. It's used to adopt databases that already
+// have the schema created by those migrations.
+func Baseline(ctx context.Context, conn xpgx.Connection, migrationsDir fs.FS, version int64, opts ...Option) error {
+	options := newOptions(opts)
+	migrationPlan, err := options.migrationPlan(migrationsDir)
+	if err != nil {
+		return fmt.Errorf("migrations: building migration plan: %w", err)
+	}
+	return withLock(ctx, conn, options, func(conn xpgx.Connection) error {
+		if err := createTable(ctx, conn, options.table()); err != nil {
+			return fmt.Errorf("migrations: creating table: %w", err)
+		}
+		applied, err := appliedMigrations(ctx, conn, options.table())
+		if err != nil {
+			return fmt.Errorf("migrations: querying for applied migrations: %w", err)
+		}
+		if err := verifyClean(applied); err != nil {
+			return err
+		}
+		for _, p := range pendingMigrations(migrationPlan, applied) {
+			if p.Version > version {
+				break
+			}
+			// the duration is not recorded, since the migration doesn't run
+			if err := insertMigrationRecord(ctx, conn, options.table(), newMigrationRecord(p, options)); err != nil {
+				return fmt.Errorf("migrations: recording baseline of %s: %w", p.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// resolveBaseline handles databases migrated before the history was squashed
+// into a baseline migration. The records of the squashed migrations, up to
+// the version of the newest baseline and no longer part of the plan, are
+// dropped and, when the database has squashed migrations but not the
+// baseline itself, a record for it is returned, so the baseline is
+// considered applied without running it.
+func resolveBaseline(plan []MigrationDefinition, applied []appliedMigration) ([]appliedMigration, *appliedMigration) {
+	var baseline *MigrationDefinition
+	for i := range plan {
+		if plan[i].Baseline {
+			baseline = &plan[i]
+		}
+	}
+	if baseline == nil {
+		return applied, nil
+	}
+	var (
+		resolved        = make([]appliedMigration, 0, len(applied))
+		predating       *appliedMigration
+		baselineApplied bool
+	)
+	for _, a := range applied {
+		baselineApplied = baselineApplied || a.Name == baseline.Name
+		version, err := appliedVersion(plan, a.Name)
+		if err != nil || version > baseline.Version || a.Name == baseline.Name || isRepeatableName(a.Name) {
+			resolved = append(resolved, a)
+			continue
+		}
+		predating = &a
+		if slices.ContainsFunc(plan, func(definition MigrationDefinition) bool {
+			return definition.Name == a.Name
+		}) {
+			resolved = append(resolved, a)
+		}
+	}
+	if predating == nil || baselineApplied {
+		return resolved, nil
+	}
+	checksum := baseline.Checksum()
+	return resolved, &appliedMigration{
+		Name:      baseline.Name,
+		Checksum:  &checksum,
+		AppliedAt: predating.AppliedAt,
+	}
+}
+
+// Squash writes a baseline migration with the content of every applied
+// migration, in order, and returns the version of the last one.
+// The output should be saved as <version>_<description>.baseline.sql and the
+// squashed files removed: databases that applied the squashed migrations
+// consider the baseline applied, new databases only run the baseline.
+// Go migrations and backfills can't be squashed and are only referenced on a comment,
+// repeatable migrations are not squashed.
+func Squash(ctx context.Context, conn xpgx.Connection, migrationsDir fs.FS, w io.Writer, opts ...Option) (int64, error) {
+	options := newOptions(opts)
+	migrationPlan, err := options.migrationPlan(migrationsDir)
+	if err != nil {
+		return 0, fmt.Errorf("migrations: building migration plan: %w", err)
+	}
+	applied, err := readAppliedMigrations(ctx, conn, options.table())
+	if err != nil {
+		return 0, fmt.Errorf("migrations: querying for applied migrations: %w", err)
+	}
+	if err := verifyClean(applied); err != nil {
+		return 0, err
+	}
+	appliedNames := make(map[string]struct{}, len(applied))
+	for _, a := range applied {
+		appliedNames[a.Name] = struct{}{}
+	}
+	var (
+		version     int64
+		transaction = true
+		b           strings.Builder
+	)
+	for _, definition := range migrationPlan {
+		if _, ok := appliedNames[definition.Name]; !ok || definition.Repeatable {
+			continue
+		}
+		version = definition.Version
+		switch definition.Kind {
+		case KindGo:
+			_, _ = fmt.Fprintf(&b, "\n-- %s: Go migration not included\n", definition.Name)
+			continue
+		case KindBackfill:
+			_, _ = fmt.Fprintf(&b, "\n-- %s: backfill not included\n", definition.Name)
+			continue
+		}
+		transaction = transaction && definition.RunInsideTransaction
+		_, _ = fmt.Fprintf(&b, "\n-- %s\n%s\n", definition.Name, squashContent(definition.Content))
+	}
+	if version == 0 {
+		return 0, fmt.Errorf("migrations: squashing: no migration applied")
+	}
+	header := fmt.Sprintf("-- baseline of the migrations up to version %d\n", version)
+	if !transaction {
+		// statements are executed one at a time, so the baseline works
+		// with statements that can't run inside a transaction
+		header += directivePrefix + " no-tx\n"
+	}
+	if _, err := io.WriteString(w, header+b.String()); err != nil {
+		return 0, fmt.Errorf("migrations: writing baseline: %w", err)
+	}
+	return version, nil
+}
+
+// squashContent returns the migration content with the directives disabled,
+// so they don't apply to the whole baseline.
+func squashContent(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasSuffix(content, ";") {
+		content += ";"
+	}
+	return strings.ReplaceAll(content, directivePrefix, "-- squashed directive:")
+}
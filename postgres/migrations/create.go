@@ -0,0 +1,64 @@
+package migrations
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// timestampLayout is the layout of timestamp versions.
+const timestampLayout = "20060102150405"
+
+// invalidDescription matches the characters not allowed on a description,
+// dots would be parsed as attributes and slashes as directories.
+var invalidDescription = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// migrationDescription returns the description used on a file name,
+// lower cased with every run of characters other than letters, digits
+// and underscores replaced by an underscore.
+func migrationDescription(description string) string {
+	return strings.Trim(invalidDescription.ReplaceAllString(strings.ToLower(description), "_"), "_")
+}
+
+// NewMigrationName returns the file name for a new migration on the directory,
+// following the same rules used to build the migration plan.
+// The version is the current UTC time when timestamp is true or the directory
+// already uses timestamp versions, otherwise it's the latest version plus one,
+// padded like the existing files (three digits by default).
+// The subdirectories are considered, as they are part of the same plan.
+func NewMigrationName(migrationsDir fs.FS, description string, timestamp bool, now time.Time) (string, error) {
+	files, err := sourceFiles(migrationsDir)
+	if err != nil {
+		return "", fmt.Errorf("migrations: reading directory: %w", err)
+	}
+	var (
+		latest int64
+		width  = 3
+	)
+	for _, f := range files {
+		parsed, err := parseMigrationName(f)
+		if err != nil {
+			return "", fmt.Errorf("migrations: %w", err)
+		}
+		if parsed.Repeatable || parsed.Version < latest {
+			continue
+		}
+		latest = parsed.Version
+		width = len(migrationPrefix(path.Base(f)))
+	}
+	description = migrationDescription(description)
+	if description != "" {
+		description = "_" + description
+	}
+	if timestamp || width == len(timestampLayout) {
+		version := now.UTC().Format(timestampLayout)
+		if v, _ := migrationVersion(version + ".sql"); v <= latest {
+			return "", fmt.Errorf("migrations: timestamp version %s is not after the latest version %d", version, latest)
+		}
+		return version + description + ".sql", nil
+	}
+	return fmt.Sprintf("%0*d%s.sql", width, latest+1, description), nil
+}
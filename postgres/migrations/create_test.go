@@ -0,0 +1,67 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMigrationName(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	t.Run("empty directory", func(t *testing.T) {
+		t.Parallel()
+		name, err := NewMigrationName(fstest.MapFS{}, "Create Users", false, now)
+		require.NoError(t, err)
+		require.Equal(t, "001_create_users.sql", name)
+		name, err = NewMigrationName(fstest.MapFS{}, "create users", true, now)
+		require.NoError(t, err)
+		require.Equal(t, "20240301120000_create_users.sql", name)
+	})
+	t.Run("description", func(t *testing.T) {
+		t.Parallel()
+		for description, expected := range map[string]string{
+			"v1.2 fix":             "001_v1_2_fix.sql",
+			"drop.down":            "001_drop_down.sql",
+			"index.no-tx":          "001_index_no_tx.sql",
+			"users/add email":      "001_users_add_email.sql",
+			"  Add   Index!  ":     "001_add_index.sql",
+			"keep_under_scores 42": "001_keep_under_scores_42.sql",
+		} {
+			name, err := NewMigrationName(fstest.MapFS{}, description, false, now)
+			require.NoError(t, err)
+			require.Equal(t, expected, name)
+			// the name is parsed back with the same description and no attributes
+			parsed, err := parseMigrationName(name)
+			require.NoError(t, err)
+			require.Equal(t, strings.TrimSuffix(strings.TrimPrefix(expected, "001_"), ".sql"), parsed.Description)
+			require.Empty(t, parsed.Attributes)
+		}
+	})
+	t.Run("sequential versions", func(t *testing.T) {
+		t.Parallel()
+		name, err := NewMigrationName(fstest.MapFS{
+			"0001.sql":         &fstest.MapFile{},
+			"0009_a.no-tx.sql": &fstest.MapFile{},
+			"0009_a.down.sql":  &fstest.MapFile{},
+			"R.refresh_x.sql":  &fstest.MapFile{},
+			"users/0011_b.sql": &fstest.MapFile{},
+		}, "add index", false, now)
+		require.NoError(t, err)
+		require.Equal(t, "0012_add_index.sql", name)
+	})
+	t.Run("timestamp versions", func(t *testing.T) {
+		t.Parallel()
+		migrationsDir := fstest.MapFS{
+			"20240101000000_a.sql": &fstest.MapFile{},
+		}
+		name, err := NewMigrationName(migrationsDir, "", false, now)
+		require.NoError(t, err)
+		require.Equal(t, "20240301120000.sql", name)
+		_, err = NewMigrationName(migrationsDir, "", false, now.AddDate(-1, 0, 0))
+		require.ErrorContains(t, err, "is not after the latest version")
+	})
+}
@@ -0,0 +1,118 @@
+package migrations
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/crossworth/pkgs/postgres/xpgx"
+	"github.com/jackc/pgx/v5"
+)
+
+// directivePrefix is the prefix of the comments with migration directives.
+const directivePrefix = "-- migrate:"
+
+// applyDirectives parses the directives on the header of the migration
+// content and applies them to the definition.
+// The header is every line before the first line that is not empty or a comment,
+// directives have the format:
+//
+//	-- migrate: no-tx
+//	-- migrate: transaction=false
+//	-- migrate: lock_timeout=5s
+//	-- migrate: statement_timeout=1min
+//	-- migrate: search_path=app, public
+//	-- migrate: lint-ignore=create-index-not-concurrently, alter-column-type
+func applyDirectives(definition *MigrationDefinition) error {
+	scanner := bufio.NewScanner(strings.NewReader(definition.Content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		directive, ok := strings.CutPrefix(line, directivePrefix)
+		if !ok {
+			continue
+		}
+		key, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "no-tx":
+			definition.RunInsideTransaction = false
+		case "transaction":
+			switch value {
+			case "true":
+				definition.RunInsideTransaction = true
+			case "false":
+				definition.RunInsideTransaction = false
+			default:
+				return fmt.Errorf("invalid transaction value %q", value)
+			}
+		case "lock_timeout":
+			definition.LockTimeout = value
+		case "statement_timeout":
+			definition.StatementTimeout = value
+		case "search_path":
+			definition.SearchPath = value
+		case "phase":
+			phase, err := ParsePhase(value)
+			if err != nil {
+				return err
+			}
+			definition.Phase = phase
+		case "lint-ignore":
+			for _, rule := range strings.Split(value, ",") {
+				if rule = strings.TrimSpace(rule); rule != "" {
+					definition.LintIgnore = append(definition.LintIgnore, rule)
+				}
+			}
+		default:
+			return fmt.Errorf("unknown directive %q", key)
+		}
+	}
+	return scanner.Err()
+}
+
+// setting is a configuration parameter applied before running a migration.
+type setting struct {
+	name  string
+	value string
+}
+
+// migrationSettings returns the settings of the migration definition.
+func migrationSettings(definition MigrationDefinition) []setting {
+	var settings []setting
+	for _, s := range []setting{
+		{name: "lock_timeout", value: definition.LockTimeout},
+		{name: "statement_timeout", value: definition.StatementTimeout},
+		{name: "search_path", value: definition.SearchPath},
+	} {
+		if s.value != "" {
+			settings = append(settings, s)
+		}
+	}
+	return settings
+}
+
+// applySettings applies the settings, when local is true the settings
+// only last until the end of the current transaction (SET LOCAL).
+// The previous values are returned, so session settings can be restored.
+func applySettings(ctx context.Context, conn xpgx.Connection, settings []setting, local bool) ([]setting, error) {
+	previous := make([]setting, 0, len(settings))
+	for _, s := range settings {
+		rows, _ := conn.Query(ctx, `SELECT current_setting($1);`, s.name)
+		value, err := pgx.CollectOneRow(rows, pgx.RowTo[string])
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", s.name, err)
+		}
+		previous = append(previous, setting{name: s.name, value: value})
+		if _, err := conn.Exec(ctx, `SELECT set_config($1, $2, $3);`, s.name, s.value, local); err != nil {
+			return nil, fmt.Errorf("setting %s: %w", s.name, err)
+		}
+	}
+	return previous, nil
+}
@@ -0,0 +1,106 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/crossworth/pkgs/postgres/xpgx"
+	"github.com/jackc/pgx/v5"
+)
+
+// DirtyError is returned when migrations started but did not complete,
+// it usually happens when a migration executed outside a transaction fails
+// halfway, leaving the database partially changed.
+type DirtyError struct {
+	Migrations []string
+}
+
+// Error implements the error interface.
+func (e *DirtyError) Error() string {
+	return "migrations: dirty migrations must be resolved: " + strings.Join(e.Migrations, ", ")
+}
+
+// verifyClean checks that none of the applied migrations is dirty.
+func verifyClean(applied []appliedMigration) error {
+	var dirty []string
+	for _, a := range applied {
+		if a.Dirty {
+			dirty = append(dirty, a.Name)
+		}
+	}
+	if len(dirty) > 0 {
+		return &DirtyError{Migrations: dirty}
+	}
+	return nil
+}
+
+// markMigrationStarted inserts a dirty migration record on the migration table,
+// the duration is recorded when the migration completes.
+func markMigrationStarted(ctx context.Context, conn xpgx.Executable, table pgx.Identifier, record migrationRecord) error {
+	if _, err := conn.Exec(ctx, `INSERT INTO `+table.Sanitize()+` (name, checksum, duration_ms, hostname, app_version, transactional, dirty)
+VALUES ($1, $2, $3, $4, $5, $6, TRUE);`, record.args()...); err != nil {
+		return fmt.Errorf("migrations: marking migration as started: %w", err)
+	}
+	return nil
+}
+
+// markMigrationCompleted marks a started migration record as completed.
+func markMigrationCompleted(ctx context.Context, conn xpgx.Executable, table pgx.Identifier, name string, duration time.Duration) error {
+	if _, err := conn.Exec(ctx, `UPDATE `+table.Sanitize()+` SET dirty = FALSE, statements_applied = NULL, applied_at = NOW(),
+duration_ms = $2, transactional = FALSE WHERE name = $1;`, name, duration.Milliseconds()); err != nil {
+		return fmt.Errorf("migrations: marking migration as completed: %w", err)
+	}
+	return nil
+}
+
+// markMigrationDirty marks an applied migration record as dirty,
+// it's used before reverting migrations outside a transaction.
+func markMigrationDirty(ctx context.Context, conn xpgx.Executable, table pgx.Identifier, name string) error {
+	if _, err := conn.Exec(ctx, `UPDATE `+table.Sanitize()+` SET dirty = TRUE WHERE name = $1;`, name); err != nil {
+		return fmt.Errorf("migrations: marking migration as dirty: %w", err)
+	}
+	return nil
+}
+
+// recordStatementsApplied records how many statements of a dirty migration were applied.
+func recordStatementsApplied(ctx context.Context, conn xpgx.Executable, table pgx.Identifier, name string, statements int) error {
+	if _, err := conn.Exec(ctx, `UPDATE `+table.Sanitize()+` SET statements_applied = $2 WHERE name = $1;`, name, statements); err != nil {
+		return fmt.Errorf("migrations: recording statements applied: %w", err)
+	}
+	return nil
+}
+
+// MarkResolved marks the dirty migration with the given name as completed.
+// It should be used after the operator finished the migration by hand.
+func MarkResolved(ctx context.Context, conn xpgx.Connection, name string, opts ...Option) error {
+	options := newOptions(opts)
+	return withLock(ctx, conn, options, func(conn xpgx.Connection) error {
+		res, err := conn.Exec(ctx, `UPDATE `+options.table().Sanitize()+` SET dirty = FALSE, statements_applied = NULL WHERE name = $1 AND dirty;`, name)
+		if err != nil {
+			return fmt.Errorf("migrations: marking migration %s as resolved: %w", name, err)
+		}
+		if res.RowsAffected() == 0 {
+			return fmt.Errorf("migrations: marking migration %s as resolved: no dirty migration found", name)
+		}
+		return nil
+	})
+}
+
+// Force discards the record of the dirty migration with the given name,
+// so the next Migrate applies it again from the start.
+// It should be used after the operator reverted the partial changes by hand.
+func Force(ctx context.Context, conn xpgx.Connection, name string, opts ...Option) error {
+	options := newOptions(opts)
+	return withLock(ctx, conn, options, func(conn xpgx.Connection) error {
+		res, err := conn.Exec(ctx, `DELETE FROM `+options.table().Sanitize()+` WHERE name = $1 AND dirty;`, name)
+		if err != nil {
+			return fmt.Errorf("migrations: forcing migration %s: %w", name, err)
+		}
+		if res.RowsAffected() == 0 {
+			return fmt.Errorf("migrations: forcing migration %s: no dirty migration found", name)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,126 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/crossworth/pkgs/postgres/xpgx"
+	"github.com/jackc/pgx/v5"
+)
+
+// DriftedMigration is an applied migration whose file changed or disappeared.
+type DriftedMigration struct {
+	Name string
+	// Missing is true when the migration file no longer exists.
+	Missing bool
+	// AppliedChecksum is the checksum recorded when the migration was applied.
+	AppliedChecksum string
+	// CurrentChecksum is the checksum of the current migration file,
+	// empty when the file is missing.
+	CurrentChecksum string
+}
+
+// DriftError is returned when applied migrations no longer match the migration files.
+type DriftError struct {
+	Migrations []DriftedMigration
+}
+
+// Error implements the error interface.
+func (e *DriftError) Error() string {
+	parts := make([]string, 0, len(e.Migrations))
+	for _, m := range e.Migrations {
+		if m.Missing {
+			parts = append(parts, m.Name+" is missing")
+			continue
+		}
+		parts = append(parts, m.Name+" changed")
+	}
+	return "migrations: applied migrations drifted: " + strings.Join(parts, ", ")
+}
+
+// detectDrift returns the applied migrations that changed or are missing from the plan.
+// Records without a checksum, created before checksums were recorded, are ignored.
+func detectDrift(plan []MigrationDefinition, applied []appliedMigration) []DriftedMigration {
+	checksums := make(map[string]string, len(plan))
+	for _, definition := range plan {
+		checksums[definition.Name] = definition.Checksum()
+	}
+	var drifted []DriftedMigration
+	for _, a := range applied {
+		if isRepeatableName(a.Name) {
+			// repeatable migrations are applied again when they change
+			continue
+		}
+		current, ok := checksums[a.Name]
+		switch {
+		case !ok:
+			drifted = append(drifted, DriftedMigration{
+				Name:            a.Name,
+				Missing:         true,
+				AppliedChecksum: valueOrEmpty(a.Checksum),
+			})
+		case a.Checksum != nil && *a.Checksum != current:
+			drifted = append(drifted, DriftedMigration{
+				Name:            a.Name,
+				AppliedChecksum: *a.Checksum,
+				CurrentChecksum: current,
+			})
+		}
+	}
+	return drifted
+}
+
+// updateMigrationChecksum updates the checksum of a migration record.
+func updateMigrationChecksum(ctx context.Context, conn xpgx.Executable, table pgx.Identifier, name string, checksum string) error {
+	if _, err := conn.Exec(ctx, `UPDATE `+table.Sanitize()+` SET checksum = $2 WHERE name = $1;`, name, checksum); err != nil {
+		return fmt.Errorf("migrations: updating migration checksum: %w", err)
+	}
+	return nil
+}
+
+// verifyChecksums checks the applied migrations against the plan.
+// Records without a checksum are stamped with the current checksum.
+// When repair is true, changed migrations are re-stamped instead of reported,
+// missing migrations are always reported.
+func verifyChecksums(ctx context.Context, conn xpgx.Connection, table pgx.Identifier, plan []MigrationDefinition, applied []appliedMigration, repair bool) error {
+	for i, a := range applied {
+		if a.Checksum != nil {
+			continue
+		}
+		idx := slices.IndexFunc(plan, func(definition MigrationDefinition) bool {
+			return definition.Name == a.Name
+		})
+		if idx == -1 {
+			continue
+		}
+		checksum := plan[idx].Checksum()
+		if err := updateMigrationChecksum(ctx, conn, table, a.Name, checksum); err != nil {
+			return err
+		}
+		applied[i].Checksum = &checksum
+	}
+	var drifted []DriftedMigration
+	for _, d := range detectDrift(plan, applied) {
+		if repair && !d.Missing {
+			if err := updateMigrationChecksum(ctx, conn, table, d.Name, d.CurrentChecksum); err != nil {
+				return err
+			}
+			continue
+		}
+		drifted = append(drifted, d)
+	}
+	if len(drifted) > 0 {
+		return &DriftError{Migrations: drifted}
+	}
+	return nil
+}
+
+// valueOrEmpty returns the value pointed by s or an empty string when s is nil.
+func valueOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
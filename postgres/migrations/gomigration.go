@@ -0,0 +1,74 @@
+package migrations
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+
+	"github.com/crossworth/pkgs/postgres/xpgx"
+)
+
+// MigrationFunc is the signature of a migration implemented in Go.
+// When the migration runs inside a transaction conn is the transaction.
+type MigrationFunc func(ctx context.Context, conn xpgx.Connection) error
+
+// GoMigration is a migration implemented in Go, registered with WithGoMigrations.
+// It's recorded on the migration table as <version>_<description>.go,
+// with the version padded to three digits.
+type GoMigration struct {
+	Version     int64
+	Description string
+	Up          MigrationFunc
+	// Down reverts the migration, it's optional.
+	Down MigrationFunc
+	// NoTransaction runs the migration outside a transaction, like the
+	// no-tx attribute of SQL files, migrations run inside one by default.
+	NoTransaction bool
+	// Phase is the deploy phase of the migration, PhaseExpand by default.
+	Phase Phase
+}
+
+// name returns the name used to record the migration.
+func (g GoMigration) name() string {
+	if g.Description == "" {
+		return fmt.Sprintf("%03d.go", g.Version)
+	}
+	return fmt.Sprintf("%03d_%s.go", g.Version, g.Description)
+}
+
+// validate checks the Go migration can be applied.
+func (g GoMigration) validate() error {
+	if g.Up == nil {
+		return fmt.Errorf("go migration %s requires the up function", g.name())
+	}
+	if g.Phase != "" {
+		if _, err := ParsePhase(string(g.Phase)); err != nil {
+			return fmt.Errorf("go migration %s: %w", g.name(), err)
+		}
+	}
+	return nil
+}
+
+// definition returns the migration definition of the Go migration.
+func (g GoMigration) definition() MigrationDefinition {
+	definition := MigrationDefinition{
+		Name:                 g.name(),
+		Version:              g.Version,
+		Description:          g.Description,
+		RunInsideTransaction: !g.NoTransaction,
+		Phase:                cmp.Or(g.Phase, PhaseExpand),
+		Kind:                 KindGo,
+		Func:                 g.Up,
+	}
+	if g.Down != nil {
+		definition.Down = &MigrationDefinition{
+			Name:                 g.name(),
+			Version:              g.Version,
+			Description:          g.Description,
+			RunInsideTransaction: !g.NoTransaction,
+			Kind:                 KindGo,
+			Func:                 g.Down,
+		}
+	}
+	return definition
+}
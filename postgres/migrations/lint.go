@@ -0,0 +1,248 @@
+package migrations
+
+import (
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// Severity is the severity of a lint issue.
+type Severity string
+
+const (
+	// SeverityWarning is used for statements that may be slow or lock tables.
+	SeverityWarning Severity = "warning"
+	// SeverityError is used for statements that fail or rewrite tables.
+	SeverityError Severity = "error"
+)
+
+// Lint rules reported by Lint, they can be suppressed per file with the
+// lint-ignore directive.
+const (
+	// RuleCreateIndexNotConcurrently reports indexes created without CONCURRENTLY,
+	// which block writes to the table while the index is built.
+	RuleCreateIndexNotConcurrently = "create-index-not-concurrently"
+	// RuleAddColumnNotNullWithoutDefault reports NOT NULL columns added without
+	// a default, which fail when the table has rows.
+	RuleAddColumnNotNullWithoutDefault = "add-column-not-null-without-default"
+	// RuleAlterColumnType reports column type changes, which may rewrite the table.
+	RuleAlterColumnType = "alter-column-type"
+	// RuleConcurrentlyInTransaction reports CONCURRENTLY used on migrations
+	// executed inside a transaction, which always fail.
+	RuleConcurrentlyInTransaction = "concurrently-in-transaction"
+)
+
+// LintIssue is a risky statement found by Lint.
+type LintIssue struct {
+	Migration string
+	Statement string
+	Rule      string
+	Severity  Severity
+	Message   string
+}
+
+// String returns the issue formatted as a single line.
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s: %s: %s (%s)", i.Migration, i.Severity, i.Message, i.Rule)
+}
+
+// Lint parses the migrations and reports risky statements, in the plan order.
+// Tables created on the same migration are not reported, since they are empty.
+// Go migrations and backfills are not linted.
+func Lint(migrationsDir fs.FS, opts ...Option) ([]LintIssue, error) {
+	options := newOptions(opts)
+	migrationPlan, err := options.migrationPlan(migrationsDir)
+	if err != nil {
+		return nil, fmt.Errorf("migrations: building migration plan: %w", err)
+	}
+	var issues []LintIssue
+	for _, definition := range migrationPlan {
+		issues = append(issues, lintMigration(definition)...)
+		if definition.Down != nil {
+			issues = append(issues, lintMigration(*definition.Down)...)
+		}
+	}
+	return issues, nil
+}
+
+// lintMigration returns the issues of a single migration.
+func lintMigration(definition MigrationDefinition) []LintIssue {
+	if definition.Kind == KindGo || definition.Kind == KindBackfill {
+		return nil
+	}
+	var (
+		issues  []LintIssue
+		created = make(map[string]struct{})
+	)
+	report := func(statement string, rule string, severity Severity, message string) {
+		if slices.Contains(definition.LintIgnore, rule) {
+			return
+		}
+		issues = append(issues, LintIssue{
+			Migration: definition.Name,
+			Statement: statement,
+			Rule:      rule,
+			Severity:  severity,
+			Message:   message,
+		})
+	}
+	for _, statement := range splitStatements(definition.Content) {
+		tokens := sqlTokens(statement)
+		switch {
+		case matchTokens(tokens, "CREATE", "TABLE"):
+			created[tableName(tokens[2:])] = struct{}{}
+		case matchTokens(tokens, "CREATE", "INDEX"), matchTokens(tokens, "CREATE", "UNIQUE", "INDEX"):
+			concurrently := slices.Contains(tokens, "CONCURRENTLY")
+			if concurrently && definition.RunInsideTransaction {
+				report(statement, RuleConcurrentlyInTransaction, SeverityError, "CREATE INDEX CONCURRENTLY cannot run inside a transaction, use the no-tx attribute")
+			}
+			if idx := slices.Index(tokens, "ON"); !concurrently && idx != -1 {
+				if _, ok := created[tableName(tokens[idx+1:])]; !ok {
+					report(statement, RuleCreateIndexNotConcurrently, SeverityWarning, "CREATE INDEX without CONCURRENTLY blocks writes to the table")
+				}
+			}
+		case matchTokens(tokens, "DROP", "INDEX", "CONCURRENTLY"), matchTokens(tokens, "REINDEX"):
+			if slices.Contains(tokens, "CONCURRENTLY") && definition.RunInsideTransaction {
+				report(statement, RuleConcurrentlyInTransaction, SeverityError, tokens[0]+" CONCURRENTLY cannot run inside a transaction, use the no-tx attribute")
+			}
+		case matchTokens(tokens, "ALTER", "TABLE"):
+			table := tableName(tokens[2:])
+			if _, ok := created[table]; ok {
+				continue
+			}
+			for _, action := range splitTokens(tokens[2:]) {
+				switch {
+				case slices.Contains(action, "ADD") && containsSequence(action, "NOT", "NULL") && !slices.Contains(action, "DEFAULT") && !slices.Contains(action, "CONSTRAINT"):
+					report(statement, RuleAddColumnNotNullWithoutDefault, SeverityError, "adding a NOT NULL column without a DEFAULT fails when the table has rows")
+				case isAlterColumnType(action):
+					report(statement, RuleAlterColumnType, SeverityWarning, "changing the column type may rewrite the table while holding an exclusive lock")
+				}
+			}
+		}
+	}
+	return issues
+}
+
+// sqlTokens returns the upper cased words and punctuation of the statement,
+// comments and string literals are skipped and quoted identifiers are kept as is.
+func sqlTokens(statement string) []string {
+	var tokens []string
+	for i := 0; i < len(statement); i++ {
+		c := statement[i]
+		switch {
+		case strings.HasPrefix(statement[i:], "--"):
+			end := strings.IndexByte(statement[i:], '\n')
+			if end == -1 {
+				return tokens
+			}
+			i += end
+		case strings.HasPrefix(statement[i:], "/*"):
+			end := strings.Index(statement[i:], "*/")
+			if end == -1 {
+				return tokens
+			}
+			i += end + 1
+		case c == '\'':
+			escapes := i > 0 && (statement[i-1] == 'e' || statement[i-1] == 'E')
+			for i++; i < len(statement) && statement[i] != '\''; i++ {
+				if escapes && statement[i] == '\\' {
+					i++
+				}
+			}
+		case c == '$' && dollarQuoteTag(statement, i) != "":
+			tag := dollarQuoteTag(statement, i)
+			end := strings.Index(statement[i+len(tag):], tag)
+			if end == -1 {
+				return tokens
+			}
+			i += len(tag) + end + len(tag) - 1
+		case isIdentifierChar(c) || c == '"' || c == '.':
+			start := i
+			for ; i < len(statement) && (isIdentifierChar(statement[i]) || statement[i] == '"' || statement[i] == '.'); i++ {
+				if statement[i] == '"' {
+					for i++; i < len(statement) && statement[i] != '"'; i++ {
+					}
+				}
+			}
+			word := statement[start:min(i, len(statement))]
+			if !strings.Contains(word, `"`) {
+				word = strings.ToUpper(word)
+			}
+			tokens = append(tokens, word)
+			i--
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, string(c))
+		}
+	}
+	return tokens
+}
+
+// matchTokens check if the tokens start with the given words.
+func matchTokens(tokens []string, words ...string) bool {
+	return len(tokens) >= len(words) && slices.Equal(tokens[:len(words)], words)
+}
+
+// containsSequence check if the tokens contain the given words in sequence.
+func containsSequence(tokens []string, words ...string) bool {
+	for i := range tokens {
+		if matchTokens(tokens[i:], words...) {
+			return true
+		}
+	}
+	return false
+}
+
+// tableName returns the normalized table name at the start of the tokens,
+// skipping IF [NOT] EXISTS and ONLY.
+func tableName(tokens []string) string {
+	for _, token := range tokens {
+		switch token {
+		case "IF", "NOT", "EXISTS", "ONLY":
+			continue
+		}
+		return strings.ToLower(strings.ReplaceAll(token, `"`, ""))
+	}
+	return ""
+}
+
+// isAlterColumnType check if the ALTER TABLE action changes a column type,
+// ALTER [COLUMN] name [SET DATA] TYPE.
+func isAlterColumnType(action []string) bool {
+	idx := slices.Index(action, "ALTER")
+	if idx == -1 {
+		return false
+	}
+	rest := action[idx+1:]
+	if matchTokens(rest, "COLUMN") {
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		return false
+	}
+	rest = rest[1:]
+	return matchTokens(rest, "TYPE") || matchTokens(rest, "SET", "DATA", "TYPE")
+}
+
+// splitTokens splits the tokens on the commas outside parentheses.
+func splitTokens(tokens []string) [][]string {
+	var (
+		parts [][]string
+		depth int
+		start int
+	)
+	for i, token := range tokens {
+		switch token {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",":
+			if depth == 0 {
+				parts = append(parts, tokens[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, tokens[start:])
+}
@@ -0,0 +1,72 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_sqlTokens(t *testing.T) {
+	t.Parallel()
+	require.Equal(t,
+		[]string{"ALTER", "TABLE", `"Users"`, "ADD", "COLUMN", "NAME", "VARCHAR", "DEFAULT", "NOT", "NULL"},
+		sqlTokens(`alter table "Users" -- comment
+add column name varchar /* block */ default 'not null' not null`),
+	)
+	require.Equal(t,
+		[]string{"CREATE", "INDEX", "ON", "PUBLIC.A", "(", "B", ",", "C", ")"},
+		sqlTokens(`create index on public.a (b, c)`),
+	)
+	require.Equal(t,
+		[]string{"SELECT", "E", ",", "X"},
+		sqlTokens(`select E'it\'s', x`),
+	)
+}
+
+func TestLint(t *testing.T) {
+	t.Parallel()
+	migrationsDir := fstest.MapFS{
+		"001.sql": &fstest.MapFile{Data: []byte(`
+create table users (id int, name varchar not null);
+create index on users (name);
+alter table users add column email varchar not null;
+`)},
+		"002.sql": &fstest.MapFile{Data: []byte(`
+create index users_id_idx on users (id);
+alter table users add column age int not null default 0, add column phone varchar not null;
+alter table users alter column name type text, alter column type set default 'a';
+`)},
+		"002.down.sql": &fstest.MapFile{Data: []byte(`drop index concurrently users_id_idx;`)},
+		"003.no-tx.sql": &fstest.MapFile{Data: []byte(`
+create unique index concurrently users_name_idx on users (name);
+alter table users alter name set data type varchar(100);
+`)},
+		"004.sql": &fstest.MapFile{Data: []byte(`-- migrate: lint-ignore=create-index-not-concurrently, alter-column-type
+create index users_age_idx on users (age);
+alter table users alter column age type bigint;
+create index concurrently users_email_idx on users (email);
+`)},
+	}
+	issues, err := Lint(migrationsDir)
+	require.NoError(t, err)
+	type issue struct {
+		Migration string
+		Rule      string
+		Severity  Severity
+	}
+	var got []issue
+	for _, i := range issues {
+		got = append(got, issue{Migration: i.Migration, Rule: i.Rule, Severity: i.Severity})
+	}
+	require.Equal(t, []issue{
+		{Migration: "002.sql", Rule: RuleCreateIndexNotConcurrently, Severity: SeverityWarning},
+		{Migration: "002.sql", Rule: RuleAddColumnNotNullWithoutDefault, Severity: SeverityError},
+		{Migration: "002.sql", Rule: RuleAlterColumnType, Severity: SeverityWarning},
+		{Migration: "002.down.sql", Rule: RuleConcurrentlyInTransaction, Severity: SeverityError},
+		{Migration: "003.no-tx.sql", Rule: RuleAlterColumnType, Severity: SeverityWarning},
+		{Migration: "004.sql", Rule: RuleConcurrentlyInTransaction, Severity: SeverityError},
+	}, got)
+	require.Equal(t, "create index users_id_idx on users (id)", issues[0].Statement)
+	require.Equal(t, "002.sql: warning: CREATE INDEX without CONCURRENTLY blocks writes to the table (create-index-not-concurrently)", issues[0].String())
+}
@@ -0,0 +1,93 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/crossworth/pkgs/postgres/xpgx"
+	"github.com/jackc/pgx/v5"
+)
+
+// lockPollInterval is the interval between attempts to acquire the advisory lock.
+const lockPollInterval = 250 * time.Millisecond
+
+// ErrLockTimeout is returned when the advisory lock could not be acquired in time.
+var ErrLockTimeout = errors.New("migrations: timeout waiting for the migration lock")
+
+// tryAdvisoryLock tries to acquire the session level advisory lock.
+func tryAdvisoryLock(ctx context.Context, conn xpgx.Queryable, key int64) (bool, error) {
+	rows, _ := conn.Query(ctx, `SELECT pg_try_advisory_lock($1);`, key)
+	locked, err := pgx.CollectOneRow(rows, pgx.RowTo[bool])
+	if err != nil {
+		return false, fmt.Errorf("acquiring advisory lock: %w", err)
+	}
+	return locked, nil
+}
+
+// advisoryLock waits until the session level advisory lock is acquired,
+// the timeout is reached or the context is done.
+func advisoryLock(ctx context.Context, conn xpgx.Queryable, key int64, timeout time.Duration) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for {
+		locked, err := tryAdvisoryLock(ctx, conn, key)
+		if err != nil {
+			return err
+		}
+		if locked {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return ErrLockTimeout
+		case <-ticker.C:
+		}
+	}
+}
+
+// advisoryUnlock releases the session level advisory lock.
+func advisoryUnlock(ctx context.Context, conn xpgx.Executable, key int64) error {
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_unlock($1);`, key); err != nil {
+		return fmt.Errorf("releasing advisory lock: %w", err)
+	}
+	return nil
+}
+
+// withLock executes the given function holding the migration advisory lock.
+// Advisory locks are bound to the session, so when conn is a pool a single
+// connection is acquired and used for the whole function.
+func withLock(ctx context.Context, conn xpgx.Connection, options *options, fn func(conn xpgx.Connection) error) (err error) {
+	if options.lockDisabled {
+		return fn(conn)
+	}
+	if pool, ok := conn.(xpgx.Acquirable); ok {
+		c, err := pool.Acquire(ctx)
+		if err != nil {
+			return fmt.Errorf("migrations: acquiring connection: %w", err)
+		}
+		defer c.Release()
+		conn = c
+	}
+	if err := advisoryLock(ctx, conn, options.advisoryLockKey(), options.lockTimeout); err != nil {
+		if errors.Is(err, ErrLockTimeout) {
+			return err
+		}
+		return fmt.Errorf("migrations: %w", err)
+	}
+	defer func() {
+		if unlockErr := advisoryUnlock(context.WithoutCancel(ctx), conn, options.advisoryLockKey()); unlockErr != nil {
+			err = errors.Join(err, fmt.Errorf("migrations: %w", unlockErr))
+		}
+	}()
+	return fn(conn)
+}
@@ -1,15 +1,21 @@
 package migrations
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"io"
 	"io/fs"
+	"math"
+	"os"
+	"path"
 	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/crossworth/pkgs/postgres/xpgx"
 	"github.com/jackc/pgx/v5"
@@ -17,38 +23,171 @@ import (
 
 // MigrationDefinition is a migration definition.
 type MigrationDefinition struct {
-	Name                 string
+	Name string
+	// Version is the numeric version from the name, either a sequential
+	// number or a timestamp.
+	Version int64
+	// Namespace is the namespace of the source the migration was read from,
+	// empty for the default source, see WithSource.
+	Namespace string
+	// Description is the optional description from the name.
+	Description          string
 	Content              string
 	RunInsideTransaction bool
+	// LockTimeout is the lock_timeout used while running the migration.
+	LockTimeout string
+	// StatementTimeout is the statement_timeout used while running the migration.
+	StatementTimeout string
+	// SearchPath is the search_path used while running the migration.
+	SearchPath string
+	// LintIgnore are the lint rules suppressed for the migration.
+	LintIgnore []string
+	// Phase is the deploy phase of the migration, see WithPhase.
+	Phase Phase
+	// Repeatable is true for migrations applied again every time their
+	// checksum changes, they run after the versioned migrations and have
+	// no version.
+	Repeatable bool
+	// Baseline is true for migrations that squash the migrations before them,
+	// see Squash.
+	Baseline bool
+	// Kind is the kind of the migration.
+	Kind MigrationKind
+	// Func is the function executed instead of Content for Go migrations.
+	Func MigrationFunc
+	// Backfill is the backfill executed for backfill migrations,
+	// Content is the batch statement.
+	Backfill *Backfill
+	// Down is the migration that reverts this one, nil when no
+	// down migration was provided.
+	Down *MigrationDefinition
 }
 
-// migrationParts returns the parts of the given migration name.
+// MigrationKind is the kind of a migration.
+type MigrationKind string
+
+const (
+	// KindSQL is the kind of migrations read from SQL files.
+	KindSQL MigrationKind = "sql"
+	// KindGo is the kind of migrations implemented in Go, see GoMigration.
+	KindGo MigrationKind = "go"
+	// KindBackfill is the kind of batched data backfills, see Backfill.
+	KindBackfill MigrationKind = "backfill"
+)
+
+// Checksum returns the hex encoded SHA-256 hash of the migration content.
+func (m MigrationDefinition) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Content))
+	return hex.EncodeToString(sum[:])
+}
+
+// migrationName is a parsed migration file name.
+// The file name grammar is <version>[_<description>][.<attribute>]*.sql,
+// where version is a sequential number (001) or a timestamp (20240301120000).
+type migrationName struct {
+	Version     int64
+	Description string
+	Attributes  []string
+	// Repeatable is true for repeatable migrations, named
+	// R_<description>[.<attribute>]*.sql or R.<description>[.<attribute>]*.sql.
+	Repeatable bool
+}
+
+// repeatablePrefix is the prefix of repeatable migrations.
+const repeatablePrefix = "R"
+
+// parseMigrationName parses the given migration file name,
+// the directories of the path are ignored.
+func parseMigrationName(name string) (migrationName, error) {
+	base := path.Base(name)
+	parts := migrationAttributes(base)
+	if len(parts) < 2 || parts[len(parts)-1] != "sql" {
+		return migrationName{}, fmt.Errorf("invalid migration name %s: missing .sql extension", name)
+	}
+	prefix := migrationPrefix(base)
+	_, description, _ := strings.Cut(parts[0], "_")
+	if prefix == repeatablePrefix {
+		attributes := parts[1 : len(parts)-1]
+		if parts[0] == repeatablePrefix && len(attributes) > 0 {
+			description, attributes = attributes[0], attributes[1:]
+		}
+		if description == "" {
+			return migrationName{}, fmt.Errorf("invalid migration name %s: repeatable migrations require a description", name)
+		}
+		return migrationName{
+			Description: description,
+			Attributes:  attributes,
+			Repeatable:  true,
+		}, nil
+	}
+	if prefix == "" || strings.TrimLeft(prefix, "0123456789") != "" {
+		return migrationName{}, fmt.Errorf("invalid migration name %s: version must be numeric", name)
+	}
+	version, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return migrationName{}, fmt.Errorf("invalid migration name %s: %w", name, err)
+	}
+	return migrationName{
+		Version:     version,
+		Description: description,
+		Attributes:  parts[1 : len(parts)-1],
+	}, nil
+}
+
+// migrationAttributes returns the parts of the given migration name.
 func migrationAttributes(name string) []string {
 	return strings.Split(name, ".")
 }
 
-// migrationPrefix returns the migration prefix from the given name.
+// migrationPrefix returns the migration prefix (the version) from the given name.
 func migrationPrefix(name string) string {
-	return migrationAttributes(name)[0]
+	prefix, _, _ := strings.Cut(migrationAttributes(name)[0], "_")
+	return prefix
 }
 
-// sortMigrations sorts the migration based on the name.
+// sortMigrations sorts the migration based on the version on the name,
+// repeatable migrations are sorted last, by name.
 func sortMigrations(migrations []string) ([]string, error) {
-	var errList []error
-	sort.Slice(migrations, func(i, j int) bool {
-		a, err := strconv.ParseInt(migrationPrefix(migrations[i]), 10, 64)
+	var (
+		errList  []error
+		versions = make(map[string]int64, len(migrations))
+	)
+	for _, m := range migrations {
+		parsed, err := parseMigrationName(m)
 		if err != nil {
 			errList = append(errList, err)
-			return false
+			continue
 		}
-		b, err := strconv.ParseInt(migrationPrefix(migrations[j]), 10, 64)
-		if err != nil {
-			errList = append(errList, err)
-			return false
+		versions[m] = parsed.Version
+		if parsed.Repeatable {
+			versions[m] = math.MaxInt64
+		}
+	}
+	if len(errList) > 0 {
+		return migrations, errors.Join(errList...)
+	}
+	sort.SliceStable(migrations, func(i, j int) bool {
+		a, b := versions[migrations[i]], versions[migrations[j]]
+		if a == b {
+			return migrations[i] < migrations[j]
 		}
 		return a < b
 	})
-	return migrations, errors.Join(errList...)
+	return migrations, nil
+}
+
+// compareDefinitions compares migration definitions by version and name,
+// repeatable migrations are sorted last, by name.
+func compareDefinitions(a, b MigrationDefinition) int {
+	switch {
+	case a.Repeatable && b.Repeatable:
+		return cmp.Compare(a.Name, b.Name)
+	case a.Repeatable:
+		return 1
+	case b.Repeatable:
+		return -1
+	}
+	return cmp.Or(cmp.Compare(a.Version, b.Version), cmp.Compare(a.Name, b.Name))
 }
 
 // isMigrationTx check if the migration should be executed inside a transaction given the attributes.
@@ -61,122 +200,644 @@ func isMigrationTx(attributes []string) bool {
 	return true
 }
 
-// buildMigrationPlan builds the migration plan.
-func buildMigrationPlan(folder fs.ReadDirFS) ([]MigrationDefinition, error) {
-	var (
-		plan  []MigrationDefinition
-		files []string
-	)
-	entries, err := folder.ReadDir(".")
+// isMigrationBaseline check if the migration is a baseline migration given the attributes.
+func isMigrationBaseline(attributes []string) bool {
+	return slices.Contains(attributes, "baseline")
+}
+
+// isMigrationDown check if the migration is a down migration given the attributes.
+func isMigrationDown(attributes []string) bool {
+	return slices.Contains(attributes, "down")
+}
+
+// appliedVersion returns the version of an applied migration, from the plan
+// when the migration is part of it, Go migrations are only known by the plan,
+// or from the name otherwise.
+func appliedVersion(plan []MigrationDefinition, name string) (int64, error) {
+	if idx := slices.IndexFunc(plan, func(definition MigrationDefinition) bool {
+		return definition.Name == name
+	}); idx != -1 {
+		return plan[idx].Version, nil
+	}
+	return migrationVersion(name)
+}
+
+// migrationVersion returns the migration version from the given name.
+func migrationVersion(name string) (int64, error) {
+	parsed, err := parseMigrationName(name)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	for _, e := range entries {
-		if e.IsDir() {
-			continue
+	return parsed.Version, nil
+}
+
+// source is a directory of migrations and its namespace, see WithSource.
+type source struct {
+	namespace string
+	fsys      fs.FS
+}
+
+// sourceFiles returns the files of the directory, walking the
+// subdirectories recursively, with the path relative to its root.
+func sourceFiles(fsys fs.FS) ([]string, error) {
+	var files []string
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			files = append(files, name)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// buildMigrationPlan builds the migration plan of a single directory,
+// see buildSourcesPlan.
+func buildMigrationPlan(folder fs.FS, goMigrations ...GoMigration) ([]MigrationDefinition, error) {
+	definitions := make([]MigrationDefinition, 0, len(goMigrations))
+	for _, g := range goMigrations {
+		if err := g.validate(); err != nil {
+			return nil, err
 		}
-		files = append(files, e.Name())
+		definitions = append(definitions, g.definition())
 	}
-	if files, err = sortMigrations(files); err != nil {
-		return nil, err
+	return buildSourcesPlan([]source{{fsys: folder}}, definitions)
+}
+
+// buildSourcesPlan builds the migration plan merging the sources.
+// Migrations are named by their path on the source prefixed by the namespace,
+// the files on the root of the default source (without namespace) are named
+// by the file name only.
+// Down migrations are attached to the up migration with the same version on
+// the same namespace, two up (or down) migrations with the same version on
+// a namespace are reported as an error. Migrations from different namespaces
+// are ordered by version and then by name.
+// The definitions, of Go migrations and backfills, are merged into the
+// default namespace by version.
+func buildSourcesPlan(sources []source, definitions []MigrationDefinition) ([]MigrationDefinition, error) {
+	type key struct {
+		namespace string
+		version   int64
 	}
-	for _, f := range files {
-		file, err := folder.Open(f)
-		if err != nil {
-			return nil, fmt.Errorf("opening file %s: %w", f, err)
+	var (
+		plan       []MigrationDefinition
+		ups        = make(map[key]string)
+		downs      = make(map[key]MigrationDefinition)
+		namespaces = make(map[string]struct{}, len(sources))
+	)
+	for _, s := range sources {
+		if _, ok := namespaces[s.namespace]; ok {
+			return nil, fmt.Errorf("duplicated source namespace %q", s.namespace)
 		}
-		contents, err := io.ReadAll(file)
-		_ = file.Close()
+		namespaces[s.namespace] = struct{}{}
+		if s.fsys == nil {
+			continue
+		}
+		files, err := sourceFiles(s.fsys)
 		if err != nil {
-			return nil, fmt.Errorf("reading file %s: %w", f, err)
+			return nil, err
 		}
-		isTx := isMigrationTx(migrationAttributes(f))
-		plan = append(plan, MigrationDefinition{
-			Name:                 f,
-			Content:              string(contents),
-			RunInsideTransaction: isTx,
-		})
+		if files, err = sortMigrations(files); err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			contents, err := fs.ReadFile(s.fsys, f)
+			if err != nil {
+				return nil, fmt.Errorf("reading file %s: %w", f, err)
+			}
+			parsed, err := parseMigrationName(f)
+			if err != nil {
+				return nil, err
+			}
+			name := path.Join(s.namespace, f)
+			definition := MigrationDefinition{
+				Name:                 name,
+				Namespace:            s.namespace,
+				Version:              parsed.Version,
+				Description:          parsed.Description,
+				Content:              string(contents),
+				RunInsideTransaction: isMigrationTx(parsed.Attributes),
+				Baseline:             isMigrationBaseline(parsed.Attributes),
+				Phase:                migrationPhase(parsed.Attributes),
+				Kind:                 KindSQL,
+				Repeatable:           parsed.Repeatable,
+			}
+			if err := applyDirectives(&definition); err != nil {
+				return nil, fmt.Errorf("parsing directives of %s: %w", name, err)
+			}
+			if parsed.Repeatable {
+				if isMigrationDown(parsed.Attributes) {
+					return nil, fmt.Errorf("repeatable migration %s can't be a down migration", name)
+				}
+				plan = append(plan, definition)
+				continue
+			}
+			k := key{namespace: s.namespace, version: parsed.Version}
+			if !isMigrationDown(parsed.Attributes) {
+				if other, ok := ups[k]; ok {
+					return nil, fmt.Errorf("duplicated migration version %d on %s and %s", parsed.Version, other, name)
+				}
+				ups[k] = name
+				plan = append(plan, definition)
+				continue
+			}
+			if other, ok := downs[k]; ok {
+				return nil, fmt.Errorf("duplicated down migration %s and %s", other.Name, name)
+			}
+			downs[k] = definition
+		}
+	}
+	for i := range plan {
+		k := key{namespace: plan[i].Namespace, version: plan[i].Version}
+		down, ok := downs[k]
+		if !ok || plan[i].Repeatable {
+			continue
+		}
+		plan[i].Down = &down
+		delete(downs, k)
+	}
+	for _, down := range downs {
+		return nil, fmt.Errorf("down migration %s has no matching up migration", down.Name)
 	}
+	for _, definition := range definitions {
+		k := key{version: definition.Version}
+		if other, ok := ups[k]; ok {
+			return nil, fmt.Errorf("duplicated migration version %d on %s and %s", definition.Version, other, definition.Name)
+		}
+		ups[k] = definition.Name
+		plan = append(plan, definition)
+	}
+	slices.SortStableFunc(plan, compareDefinitions)
 	return plan, nil
 }
 
-// createTable creates the migration table.
-func createTable(ctx context.Context, conn xpgx.Executable) error {
-	if _, err := conn.Exec(ctx, `
-CREATE TABLE IF NOT EXISTS migrations (
+// createTable creates the migration table, and its schema when the table is qualified.
+func createTable(ctx context.Context, conn xpgx.Executable, table pgx.Identifier) error {
+	if len(table) > 1 {
+		if _, err := conn.Exec(ctx, `CREATE SCHEMA IF NOT EXISTS `+table[:len(table)-1].Sanitize()+`;`); err != nil {
+			return fmt.Errorf("creating migration table schema: %w", err)
+		}
+	}
+	if _, err := conn.Exec(ctx, fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %[1]s (
 	id SERIAL,
 	name CHARACTER VARYING NOT NULL,
-	applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+	applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL,
+	checksum CHARACTER VARYING,
+	dirty BOOLEAN DEFAULT FALSE NOT NULL,
+	statements_applied INTEGER,
+	duration_ms BIGINT,
+	applied_by CHARACTER VARYING DEFAULT CURRENT_USER,
+	hostname CHARACTER VARYING,
+	app_version CHARACTER VARYING,
+	transactional BOOLEAN
 );
-`); err != nil {
+ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS checksum CHARACTER VARYING;
+ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS dirty BOOLEAN DEFAULT FALSE NOT NULL;
+ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS statements_applied INTEGER;
+ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS duration_ms BIGINT;
+ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS applied_by CHARACTER VARYING;
+ALTER TABLE %[1]s ALTER COLUMN applied_by SET DEFAULT CURRENT_USER;
+ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS hostname CHARACTER VARYING;
+ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS app_version CHARACTER VARYING;
+ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS transactional BOOLEAN;
+`, table.Sanitize())); err != nil {
 		return fmt.Errorf("creating migration table: %w", err)
 	}
 	return nil
 }
 
-// lastMigrationApplied returns the last migration applied.
-func lastMigrationApplied(ctx context.Context, conn xpgx.Queryable) (string, error) {
-	rows, _ := conn.Query(ctx, `SELECT name FROM migrations ORDER BY id DESC LIMIT 1;`)
-	lastMigration, err := pgx.CollectOneRow(rows, pgx.RowTo[string])
-	if errors.Is(err, pgx.ErrNoRows) {
-		return "", nil
-	}
+// appliedMigration is a record from the migration table.
+type appliedMigration struct {
+	Name      string
+	Checksum  *string
+	AppliedAt time.Time
+	// Dirty is true when the migration started but did not complete.
+	Dirty bool
+}
+
+// appliedMigrations returns all the migrations applied, in the order they were applied.
+func appliedMigrations(ctx context.Context, conn xpgx.Queryable, table pgx.Identifier) ([]appliedMigration, error) {
+	rows, _ := conn.Query(ctx, `SELECT name, checksum, applied_at, dirty FROM `+table.Sanitize()+` ORDER BY id;`)
+	applied, err := pgx.CollectRows(rows, pgx.RowToStructByPos[appliedMigration])
 	if err != nil {
-		return "", fmt.Errorf("querying for last migration: %w", err)
+		return nil, fmt.Errorf("querying for applied migrations: %w", err)
+	}
+	return applied, nil
+}
+
+// migrationRecord is a migration record inserted on the migration table,
+// the user applying it is recorded by the database.
+type migrationRecord struct {
+	Name     string
+	Checksum string
+	// Duration is how long the migration took, nil for migrations recorded
+	// as applied without running, e.g. by Baseline.
+	Duration *time.Duration
+	// Transactional is true when the migration ran inside a transaction,
+	// only recorded when the migration ran.
+	Transactional bool
+	AppVersion    string
+}
+
+// newMigrationRecord returns the record of a migration definition.
+func newMigrationRecord(definition MigrationDefinition, options *options) migrationRecord {
+	return migrationRecord{
+		Name:          definition.Name,
+		Checksum:      definition.Checksum(),
+		Transactional: definition.RunInsideTransaction,
+		AppVersion:    options.appVersion,
+	}
+}
+
+// args returns the arguments used to insert the record: name, checksum,
+// duration_ms, hostname, app_version and transactional.
+func (r migrationRecord) args() []any {
+	var (
+		duration      *int64
+		transactional *bool
+		hostname      *string
+		appVersion    *string
+	)
+	if r.Duration != nil {
+		ms := r.Duration.Milliseconds()
+		duration, transactional = &ms, &r.Transactional
+	}
+	if h, err := os.Hostname(); err == nil {
+		hostname = &h
 	}
-	return lastMigration, nil
+	if r.AppVersion != "" {
+		appVersion = &r.AppVersion
+	}
+	return []any{r.Name, r.Checksum, duration, hostname, appVersion, transactional}
 }
 
 // insertMigrationRecord inserts a migration record on the migration table.
-func insertMigrationRecord(ctx context.Context, conn xpgx.Executable, name string) error {
-	if _, err := conn.Exec(ctx, `INSERT INTO migrations (name) VALUES ($1);`, name); err != nil {
+func insertMigrationRecord(ctx context.Context, conn xpgx.Executable, table pgx.Identifier, record migrationRecord) error {
+	if _, err := conn.Exec(ctx, `INSERT INTO `+table.Sanitize()+` (name, checksum, duration_ms, hostname, app_version, transactional)
+VALUES ($1, $2, $3, $4, $5, $6);`, record.args()...); err != nil {
 		return fmt.Errorf("migrations: inserting migration record: %w", err)
 	}
 	return nil
 }
 
+// deleteMigrationRecord deletes a migration record from the migration table.
+func deleteMigrationRecord(ctx context.Context, conn xpgx.Executable, table pgx.Identifier, name string) error {
+	if _, err := conn.Exec(ctx, `DELETE FROM `+table.Sanitize()+` WHERE name = $1;`, name); err != nil {
+		return fmt.Errorf("migrations: deleting migration record: %w", err)
+	}
+	return nil
+}
+
+// execMigration executes the migration content, or function for Go migrations,
+// with the migration settings applied.
+// It must be called inside a transaction when the migration runs inside a transaction.
+// The previous settings are restored once the migration runs, so the migration
+// record is written with them, e.g. not on the search_path of the migration.
+func execMigration(ctx context.Context, conn xpgx.Connection, definition MigrationDefinition) (err error) {
+	previous, err := applySettings(ctx, conn, migrationSettings(definition), definition.RunInsideTransaction)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil && definition.RunInsideTransaction {
+			// the transaction is aborted, the settings are rolled back with it
+			return
+		}
+		_, restoreErr := applySettings(context.WithoutCancel(ctx), conn, previous, definition.RunInsideTransaction)
+		err = errors.Join(err, restoreErr)
+	}()
+	if definition.Func != nil {
+		return definition.Func(ctx, conn)
+	}
+	if definition.RunInsideTransaction {
+		_, err = conn.Exec(ctx, definition.Content)
+		return err
+	}
+	// a multi statement Exec runs as an implicit transaction,
+	// so statements like CREATE INDEX CONCURRENTLY must run one at a time
+	return execStatements(ctx, conn, splitStatements(definition.Content))
+}
+
+// execStatements executes the statements one at a time,
+// returning a *StatementError when one of them fails.
+func execStatements(ctx context.Context, conn xpgx.Executable, statements []string) error {
+	for i, statement := range statements {
+		if _, err := conn.Exec(ctx, statement); err != nil {
+			return &StatementError{Index: i, Statement: statement, Err: err}
+		}
+	}
+	return nil
+}
+
 // applyMigration applies a migration definition.
-func applyMigration(ctx context.Context, conn xpgx.Connection, definition MigrationDefinition) error {
+func applyMigration(ctx context.Context, conn xpgx.Connection, options *options, definition MigrationDefinition) error {
+	if definition.Kind == KindBackfill {
+		return applyBackfill(ctx, conn, options, definition)
+	}
+	table := options.table()
+	record := newMigrationRecord(definition, options)
+	start := time.Now()
 	if definition.RunInsideTransaction {
 		if err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
-			if _, err := tx.Exec(ctx, definition.Content); err != nil {
+			if err := execMigration(ctx, tx, definition); err != nil {
 				return err
 			}
-			return insertMigrationRecord(ctx, tx, definition.Name)
+			if definition.Repeatable {
+				if err := deleteMigrationRecord(ctx, tx, table, definition.Name); err != nil {
+					return err
+				}
+			}
+			duration := time.Since(start)
+			record.Duration = &duration
+			return insertMigrationRecord(ctx, tx, table, record)
 		}); err != nil {
 			return fmt.Errorf("executing migration: %w", err)
 		}
 	} else {
-		if _, err := conn.Exec(ctx, definition.Content); err != nil {
+		if definition.Repeatable {
+			if err := deleteMigrationRecord(ctx, conn, table, definition.Name); err != nil {
+				return fmt.Errorf("executing migration: %w", err)
+			}
+		}
+		// the migration is recorded as dirty before it runs, so a failure
+		// halfway is not silently re-applied
+		if err := markMigrationStarted(ctx, conn, table, record); err != nil {
 			return fmt.Errorf("executing migration: %w", err)
 		}
-		if err := insertMigrationRecord(ctx, conn, definition.Name); err != nil {
+		if err := execMigration(ctx, conn, definition); err != nil {
+			var statementErr *StatementError
+			if errors.As(err, &statementErr) {
+				err = errors.Join(err, recordStatementsApplied(context.WithoutCancel(ctx), conn, table, definition.Name, statementErr.Index))
+			}
+			return fmt.Errorf("executing migration: %w", err)
+		}
+		if err := markMigrationCompleted(ctx, conn, table, definition.Name, time.Since(start)); err != nil {
 			return fmt.Errorf("executing migration: %w", err)
 		}
 	}
 	return nil
 }
 
+// revertMigration reverts a migration definition using its down migration.
+func revertMigration(ctx context.Context, conn xpgx.Connection, table pgx.Identifier, definition MigrationDefinition) error {
+	down := definition.Down
+	if down == nil {
+		return fmt.Errorf("migration %s has no down migration", definition.Name)
+	}
+	if down.RunInsideTransaction {
+		if err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if err := execMigration(ctx, tx, *down); err != nil {
+				return err
+			}
+			return deleteMigrationRecord(ctx, tx, table, definition.Name)
+		}); err != nil {
+			return fmt.Errorf("executing down migration: %w", err)
+		}
+	} else {
+		if err := markMigrationDirty(ctx, conn, table, definition.Name); err != nil {
+			return fmt.Errorf("executing down migration: %w", err)
+		}
+		if err := execMigration(ctx, conn, *down); err != nil {
+			return fmt.Errorf("executing down migration: %w", err)
+		}
+		if err := deleteMigrationRecord(ctx, conn, table, definition.Name); err != nil {
+			return fmt.Errorf("executing down migration: %w", err)
+		}
+	}
+	return nil
+}
+
+// pendingMigrations returns the versioned migrations from the plan that were not applied yet.
+func pendingMigrations(plan []MigrationDefinition, applied []appliedMigration) []MigrationDefinition {
+	appliedNames := make(map[string]struct{}, len(applied))
+	for _, a := range applied {
+		appliedNames[a.Name] = struct{}{}
+	}
+	var pending []MigrationDefinition
+	for _, definition := range plan {
+		if _, ok := appliedNames[definition.Name]; !ok && !definition.Repeatable {
+			pending = append(pending, definition)
+		}
+	}
+	return pending
+}
+
+// pendingRepeatables returns the repeatable migrations from the plan that
+// were not applied yet or whose checksum changed since they were applied.
+func pendingRepeatables(plan []MigrationDefinition, applied []appliedMigration) []MigrationDefinition {
+	checksums := make(map[string]string, len(applied))
+	for _, a := range applied {
+		checksums[a.Name] = valueOrEmpty(a.Checksum)
+	}
+	var pending []MigrationDefinition
+	for _, definition := range plan {
+		if !definition.Repeatable {
+			continue
+		}
+		if checksum, ok := checksums[definition.Name]; !ok || checksum != definition.Checksum() {
+			pending = append(pending, definition)
+		}
+	}
+	return pending
+}
+
+// latestVersion returns the version of the newest versioned migration on the plan.
+func latestVersion(plan []MigrationDefinition) int64 {
+	var latest int64
+	for _, definition := range plan {
+		if !definition.Repeatable {
+			latest = max(latest, definition.Version)
+		}
+	}
+	return latest
+}
+
+// isRepeatableName check if the name is the name of a repeatable migration.
+func isRepeatableName(name string) bool {
+	parsed, err := parseMigrationName(name)
+	return err == nil && parsed.Repeatable
+}
+
 // Migrate executes the migration process on the database.
 // The migration can block if huge changes are performed.
-func Migrate(ctx context.Context, conn xpgx.Connection, migrationsDir fs.ReadDirFS) error {
-	migrationPlan, err := buildMigrationPlan(migrationsDir)
+// A session level advisory lock is held while migrating, so concurrent
+// calls from several instances are serialized, see WithLockKey.
+// Every migration not applied yet is applied, in version order.
+// A *DriftError is returned when applied migrations were changed or removed,
+// unless WithRepair is provided.
+// A *DirtyError is returned while a migration that failed halfway is not
+// resolved, see MarkResolved and Force.
+// With WithStrict an *OrderError is returned when a migration would be applied
+// out of order or an applied migration is unknown.
+// The progress is reported to the Observer provided with WithObserver.
+// The migrations directory is walked recursively and merged with the
+// sources provided with WithSource, it may be nil when every migration
+// comes from a source.
+// The seeds provided with WithSeeds are applied after the migrations.
+func Migrate(ctx context.Context, conn xpgx.Connection, migrationsDir fs.FS, opts ...Option) error {
+	return MigrateTo(ctx, conn, migrationsDir, math.MaxInt64, opts...)
+}
+
+// MigrateTo migrates the database to the given target version.
+// Migrations with a version lower or equal to the target are applied,
+// applied migrations with a version greater than the target are reverted
+// in reverse order using their down migrations.
+// A target of zero reverts every migration applied.
+func MigrateTo(ctx context.Context, conn xpgx.Connection, migrationsDir fs.FS, target int64, opts ...Option) error {
+	options := newOptions(opts)
+	migrationPlan, err := options.migrationPlan(migrationsDir)
 	if err != nil {
 		return fmt.Errorf("migrations: building migration plan: %w", err)
 	}
-	if err := createTable(ctx, conn); err != nil {
-		return fmt.Errorf("migrations: creating table: %w", err)
-	}
-	migration, err := lastMigrationApplied(ctx, conn)
+	return withLock(ctx, conn, options, func(conn xpgx.Connection) error {
+		if err := createTable(ctx, conn, options.table()); err != nil {
+			return fmt.Errorf("migrations: creating table: %w", err)
+		}
+		applied, err := appliedMigrations(ctx, conn, options.table())
+		if err != nil {
+			return fmt.Errorf("migrations: querying for applied migrations: %w", err)
+		}
+		if err := verifyClean(applied); err != nil {
+			return err
+		}
+		applied, baseline := resolveBaseline(migrationPlan, applied)
+		if baseline != nil {
+			applied = append(applied, *baseline)
+		}
+		if options.strict {
+			if err := verifyOrder(migrationPlan, applied); err != nil {
+				return err
+			}
+		}
+		if err := verifyChecksums(ctx, conn, options.table(), migrationPlan, applied, options.repair); err != nil {
+			return err
+		}
+		// the baseline is only recorded once the applied migrations are verified
+		if baseline != nil {
+			record := migrationRecord{Name: baseline.Name, Checksum: *baseline.Checksum, AppVersion: options.appVersion}
+			if err := insertMigrationRecord(ctx, conn, options.table(), record); err != nil {
+				return err
+			}
+		}
+		pending, err := phaseMigrations(pendingMigrations(migrationPlan, applied), options.phase)
+		if err != nil {
+			return err
+		}
+		var steps []MigrationStep
+		for _, p := range pending {
+			if p.Version > target {
+				break
+			}
+			steps = append(steps, MigrationStep{Migration: p, Direction: DirectionUp})
+		}
+		// repeatable migrations run after the versioned migrations,
+		// only when the database is migrated to the latest version,
+		// on the expand phase
+		if latestVersion(migrationPlan) <= target && options.phase != PhaseContract {
+			for _, p := range pendingRepeatables(migrationPlan, applied) {
+				steps = append(steps, MigrationStep{Migration: p, Direction: DirectionUp})
+			}
+		}
+		var toRevert []appliedMigration
+		for _, a := range applied {
+			version, err := appliedVersion(migrationPlan, a.Name)
+			if err != nil {
+				return fmt.Errorf("migrations: parsing migration version %s: %w", a.Name, err)
+			}
+			if version > target {
+				toRevert = append(toRevert, a)
+			}
+		}
+		revertSteps, err := revertMigrations(migrationPlan, toRevert)
+		if err != nil {
+			return err
+		}
+		if err := executeSteps(ctx, conn, options, append(steps, revertSteps...)); err != nil {
+			return err
+		}
+		if options.seeds == nil || latestVersion(migrationPlan) > target || options.phase == PhaseContract {
+			return nil
+		}
+		return applySeeds(ctx, conn, options)
+	})
+}
+
+// Rollback reverts the last steps migrations applied, in reverse order,
+// using their down migrations.
+func Rollback(ctx context.Context, conn xpgx.Connection, migrationsDir fs.FS, steps int, opts ...Option) error {
+	options := newOptions(opts)
+	migrationPlan, err := options.migrationPlan(migrationsDir)
 	if err != nil {
-		return fmt.Errorf("migrations: querying for last migration applied: %w", err)
+		return fmt.Errorf("migrations: building migration plan: %w", err)
 	}
-	migrationIdx := slices.IndexFunc(migrationPlan, func(definition MigrationDefinition) bool {
-		return definition.Name == migration
+	return withLock(ctx, conn, options, func(conn xpgx.Connection) error {
+		if err := createTable(ctx, conn, options.table()); err != nil {
+			return fmt.Errorf("migrations: creating table: %w", err)
+		}
+		applied, err := appliedMigrations(ctx, conn, options.table())
+		if err != nil {
+			return fmt.Errorf("migrations: querying for applied migrations: %w", err)
+		}
+		if err := verifyClean(applied); err != nil {
+			return err
+		}
+		applied, _ = resolveBaseline(migrationPlan, applied)
+		applied = slices.DeleteFunc(applied, func(a appliedMigration) bool {
+			return isRepeatableName(a.Name)
+		})
+		steps := min(max(steps, 0), len(applied))
+		revertSteps, err := revertMigrations(migrationPlan, applied[len(applied)-steps:])
+		if err != nil {
+			return err
+		}
+		return executeSteps(ctx, conn, options, revertSteps)
 	})
-	for _, p := range migrationPlan[migrationIdx+1:] {
-		if err := applyMigration(ctx, conn, p); err != nil {
-			return fmt.Errorf("migrations: applying migration %s: %w", p.Name, err)
+}
+
+// revertMigrations returns the steps reverting the given applied migrations,
+// in reverse order.
+func revertMigrations(plan []MigrationDefinition, applied []appliedMigration) ([]MigrationStep, error) {
+	steps := make([]MigrationStep, 0, len(applied))
+	for i := len(applied) - 1; i >= 0; i-- {
+		name := applied[i].Name
+		idx := slices.IndexFunc(plan, func(definition MigrationDefinition) bool {
+			return definition.Name == name
+		})
+		if idx == -1 {
+			return nil, fmt.Errorf("migrations: reverting migration %s: migration not found", name)
+		}
+		steps = append(steps, MigrationStep{Migration: plan[idx], Direction: DirectionDown})
+	}
+	return steps, nil
+}
+
+// executeSteps executes the steps in order, notifying the observer.
+func executeSteps(ctx context.Context, conn xpgx.Connection, options *options, steps []MigrationStep) (err error) {
+	start := time.Now()
+	options.observer.BeforePlan(ctx, steps)
+	defer func() {
+		options.observer.AfterPlan(ctx, time.Since(start), err)
+	}()
+	for _, step := range steps {
+		stepStart := time.Now()
+		options.observer.BeforeMigration(ctx, step)
+		if step.Direction == DirectionDown {
+			err = revertMigration(ctx, conn, options.table(), step.Migration)
+			if err != nil {
+				err = fmt.Errorf("migrations: reverting migration %s: %w", step.Migration.Name, err)
+			}
+		} else {
+			err = applyMigration(ctx, conn, options, step.Migration)
+			if err != nil {
+				err = fmt.Errorf("migrations: applying migration %s: %w", step.Migration.Name, err)
+			}
+		}
+		options.observer.AfterMigration(ctx, step, time.Since(stepStart), err)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
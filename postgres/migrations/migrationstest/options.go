@@ -0,0 +1,78 @@
+package migrationstest
+
+import (
+	"flag"
+	"slices"
+	"strings"
+
+	"github.com/crossworth/pkgs/postgres/migrations"
+	"github.com/crossworth/pkgs/postgres/test"
+)
+
+// updateSchema is the flag used to rewrite the golden schema files,
+// e.g. go test ./... -update-schema.
+var updateSchema = flag.Bool("update-schema", false, "rewrite the golden schema files")
+
+// Option is the signature of options that can be provided to the helpers.
+type Option func(opts *options)
+
+// WithTestOptions is an option that allows providing the options used to
+// create the test database with test.NewPostgresTest.
+func WithTestOptions(testOptions ...test.Option) Option {
+	return func(opts *options) {
+		opts.testOptions = append(opts.testOptions, testOptions...)
+	}
+}
+
+// WithMigrationOptions is an option that allows providing the options used
+// to apply the migrations.
+func WithMigrationOptions(migrationOptions ...migrations.Option) Option {
+	return func(opts *options) {
+		opts.migrationOptions = append(opts.migrationOptions, migrationOptions...)
+	}
+}
+
+// WithExcludedTables is an option that allows providing tables not included
+// on the schema dump, in addition to the migration, seed and backfill tables
+// of the migration options, see migrations.HistoryTables.
+// Names are matched with or without the schema.
+func WithExcludedTables(tables ...string) Option {
+	return func(opts *options) {
+		opts.excludedTables = append(opts.excludedTables, tables...)
+	}
+}
+
+// WithUpdate is an option that allows rewriting the golden schema files,
+// overriding the -update-schema flag.
+func WithUpdate(update bool) Option {
+	return func(opts *options) {
+		opts.update = update
+	}
+}
+
+// options holds references for all the options we allow providing on the helpers.
+type options struct {
+	testOptions      []test.Option
+	migrationOptions []migrations.Option
+	excludedTables   []string
+	update           bool
+}
+
+// excluded reports if the table is excluded from the schema dump.
+func (o *options) excluded(schema string, table string) bool {
+	return slices.Contains(o.excludedTables, table) || slices.Contains(o.excludedTables, schema+"."+table)
+}
+
+// newOptions returns the options with the defaults and the given options applied.
+func newOptions(opts []Option) *options {
+	defaultOpts := &options{
+		update: *updateSchema,
+	}
+	for _, opt := range opts {
+		opt(defaultOpts)
+	}
+	for _, table := range migrations.HistoryTables(defaultOpts.migrationOptions...) {
+		defaultOpts.excludedTables = append(defaultOpts.excludedTables, strings.Join(table, "."))
+	}
+	return defaultOpts
+}
@@ -0,0 +1,28 @@
+package migrationstest
+
+import (
+	"testing"
+
+	"github.com/crossworth/pkgs/postgres/migrations"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_options_excluded(t *testing.T) {
+	t.Parallel()
+	defaultOptions := newOptions(nil)
+	require.True(t, defaultOptions.excluded("public", "migrations"))
+	require.True(t, defaultOptions.excluded("public", "migrations_seeds"))
+	require.True(t, defaultOptions.excluded("public", "migrations_backfills"))
+	require.False(t, defaultOptions.excluded("public", "users"))
+	// the history tables follow the migration options
+	customOptions := newOptions([]Option{
+		WithMigrationOptions(migrations.WithSchema("meta"), migrations.WithTableName("history")),
+		WithExcludedTables("audit"),
+	})
+	require.True(t, customOptions.excluded("meta", "history"))
+	require.True(t, customOptions.excluded("meta", "history_seeds"))
+	require.True(t, customOptions.excluded("meta", "history_backfills"))
+	require.True(t, customOptions.excluded("public", "audit"))
+	require.False(t, customOptions.excluded("public", "history"))
+	require.False(t, customOptions.excluded("public", "migrations"))
+}
@@ -0,0 +1,72 @@
+package migrationstest
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+
+	"github.com/crossworth/pkgs/postgres/migrations"
+	"github.com/crossworth/pkgs/postgres/test"
+	"github.com/stretchr/testify/require"
+)
+
+// withoutRepeatables hides the repeatable migrations from the directory.
+type withoutRepeatables struct {
+	fs.FS
+}
+
+func (f withoutRepeatables) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(f.FS, name)
+	if err != nil {
+		return nil, err
+	}
+	filtered := entries[:0]
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), "R.") && !strings.HasPrefix(e.Name(), "R_") {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered, nil
+}
+
+// VerifyRoundTrip applies each migration on a new test database, reverts it
+// with its down migration and applies it again, comparing the schema, as
+// returned by DumpSchema, to prove each down migration reverses its up
+// migration. Failures name the migration that broke.
+// Migrations without a down migration are applied but not reverted, and
+// repeatable migrations, which can't be reverted, are not applied.
+func VerifyRoundTrip(t test.TestingT, migrationsDir fs.FS, opts ...Option) {
+	if h, ok := t.(interface {
+		Helper()
+	}); ok {
+		h.Helper()
+	}
+	options := newOptions(opts)
+	ctx := context.Background()
+	pool := newDatabase(t, options)
+	dir := withoutRepeatables{migrationsDir}
+	plan, err := migrations.Plan(ctx, pool, dir, options.migrationOptions...)
+	require.NoError(t, err, "building migration plan")
+	var previous int64
+	for _, definition := range plan {
+		before, err := DumpSchema(ctx, pool, opts...)
+		require.NoError(t, err, "migration %s: dumping schema", definition.Name)
+		err = migrations.MigrateTo(ctx, pool, dir, definition.Version, options.migrationOptions...)
+		require.NoError(t, err, "migration %s: applying", definition.Name)
+		if definition.Down != nil {
+			after, err := DumpSchema(ctx, pool, opts...)
+			require.NoError(t, err, "migration %s: dumping schema", definition.Name)
+			err = migrations.MigrateTo(ctx, pool, dir, previous, options.migrationOptions...)
+			require.NoError(t, err, "migration %s: reverting", definition.Name)
+			reverted, err := DumpSchema(ctx, pool, opts...)
+			require.NoError(t, err, "migration %s: dumping schema", definition.Name)
+			require.Equal(t, before, reverted, "migration %s: the down migration doesn't restore the schema", definition.Name)
+			err = migrations.MigrateTo(ctx, pool, dir, definition.Version, options.migrationOptions...)
+			require.NoError(t, err, "migration %s: applying after reverting", definition.Name)
+			reapplied, err := DumpSchema(ctx, pool, opts...)
+			require.NoError(t, err, "migration %s: dumping schema", definition.Name)
+			require.Equal(t, after, reapplied, "migration %s: applying after reverting results in a different schema", definition.Name)
+		}
+		previous = definition.Version
+	}
+}
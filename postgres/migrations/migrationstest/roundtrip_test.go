@@ -0,0 +1,60 @@
+package migrationstest
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/crossworth/pkgs/postgres/migrations"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_withoutRepeatables(t *testing.T) {
+	t.Parallel()
+	entries, err := withoutRepeatables{fstest.MapFS{
+		"001.sql":     &fstest.MapFile{},
+		"R.views.sql": &fstest.MapFile{},
+		"R_funcs.sql": &fstest.MapFile{},
+		"README.md":   &fstest.MapFile{},
+	}}.ReadDir(".")
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	require.Equal(t, []string{"001.sql", "README.md"}, names)
+}
+
+func TestVerifyRoundTrip(t *testing.T) {
+	t.Parallel()
+	migrationsDir := fstest.MapFS{
+		"001.sql":      &fstest.MapFile{Data: []byte("create table users(id serial primary key, name varchar not null);")},
+		"001.down.sql": &fstest.MapFile{Data: []byte("drop table users;")},
+		"002.sql":      &fstest.MapFile{Data: []byte("create index users_name_idx on users(name);")},
+		"003.sql":      &fstest.MapFile{Data: []byte("alter table users add column email varchar;")},
+		"003.down.sql": &fstest.MapFile{Data: []byte("alter table users drop column email;")},
+		"R.views.sql":  &fstest.MapFile{Data: []byte("create or replace view v as select name from users;")},
+	}
+	VerifyRoundTrip(t, migrationsDir, testOptions)
+	// a down migration that doesn't reverse the up migration
+	migrationsDir["003.down.sql"] = &fstest.MapFile{Data: []byte("alter table users alter column email type text;")}
+	recorder := &recordingT{T: t}
+	VerifyRoundTrip(recorder, migrationsDir, testOptions)
+	require.NotEmpty(t, recorder.errors)
+	require.Contains(t, recorder.errors[0], "migration 003.sql: the down migration doesn't restore the schema")
+}
+
+func TestVerifyRoundTrip_historyTables(t *testing.T) {
+	t.Parallel()
+	migrationsDir := fstest.MapFS{
+		"001.sql":      &fstest.MapFile{Data: []byte("create table users(id serial primary key, name varchar not null);")},
+		"001.down.sql": &fstest.MapFile{Data: []byte("drop table users;")},
+	}
+	seedsDir := fstest.MapFS{
+		"dev/001_admin.sql": &fstest.MapFile{Data: []byte("insert into users(name) values ('admin');")},
+	}
+	// the history, seed and backfill tables are not part of the schema
+	VerifyRoundTrip(t, migrationsDir, testOptions, WithMigrationOptions(
+		migrations.WithTableName("history"),
+		migrations.WithSeeds(seedsDir, "dev"),
+	))
+}
@@ -0,0 +1,221 @@
+// Package migrationstest provides helpers to test migrations against
+// databases created with test.NewPostgresTest.
+package migrationstest
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/crossworth/pkgs/postgres/migrations"
+	"github.com/crossworth/pkgs/postgres/test"
+	"github.com/crossworth/pkgs/postgres/xpgx"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/require"
+)
+
+// userSchemas filters the catalog queries to user defined schemas.
+const userSchemas = `n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg\_%'`
+
+// column is a table column read from the catalog.
+type column struct {
+	Schema   string
+	Table    string
+	Name     string
+	Type     string
+	NotNull  bool
+	Default  *string
+	Identity string
+}
+
+// definition is an object read from the catalog with its definition,
+// Table is the table the object belongs to, if any.
+type definition struct {
+	Schema     string
+	Table      string
+	Definition string
+}
+
+// VerifySchema applies the migrations to a new test database and compares
+// the schema, as returned by DumpSchema, with the golden file.
+// The golden file is rewritten instead when the -update-schema flag
+// (or WithUpdate) is set.
+func VerifySchema(t test.TestingT, migrationsDir fs.FS, goldenFile string, opts ...Option) {
+	if h, ok := t.(interface {
+		Helper()
+	}); ok {
+		h.Helper()
+	}
+	options := newOptions(opts)
+	ctx := context.Background()
+	pool := newDatabase(t, options)
+	err := migrations.Migrate(ctx, pool, migrationsDir, options.migrationOptions...)
+	require.NoError(t, err, "applying migrations")
+	schema, err := DumpSchema(ctx, pool, opts...)
+	require.NoError(t, err, "dumping schema")
+	if options.update {
+		err := os.WriteFile(goldenFile, []byte(schema), 0o644) //nolint:gosec
+		require.NoError(t, err, "writing golden schema")
+		return
+	}
+	golden, err := os.ReadFile(goldenFile)
+	require.NoError(t, err, "reading golden schema, run the tests with -update-schema to create it")
+	require.Equal(t, string(golden), schema, "schema differs from %s, run the tests with -update-schema to update it", goldenFile)
+}
+
+// newDatabase creates a test database and returns a pool connected to it,
+// closed when the test finishes.
+func newDatabase(t test.TestingT, options *options) *pgxpool.Pool {
+	if h, ok := t.(interface {
+		Helper()
+	}); ok {
+		h.Helper()
+	}
+	testDB := test.NewPostgresTest(t, options.testOptions...)
+	pool, err := pgxpool.New(context.Background(), testDB)
+	require.NoError(t, err)
+	// registered after the database cleanup, so it runs before the database is deleted
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+// DumpSchema returns a normalized representation of the user defined schema,
+// read from the catalog: tables with their columns and constraints, indexes,
+// sequences, views and functions. Objects are sorted by name and columns by
+// name, so the result doesn't depend on the order they were created.
+// Objects belonging to extensions and the excluded tables are not included.
+func DumpSchema(ctx context.Context, conn xpgx.Queryable, opts ...Option) (string, error) {
+	options := newOptions(opts)
+	rows, _ := conn.Query(ctx, `
+		SELECT n.nspname, c.relname, a.attname, format_type(a.atttypid, a.atttypmod),
+			a.attnotnull, pg_get_expr(d.adbin, d.adrelid), a.attidentity::text
+		FROM pg_attribute a
+			JOIN pg_class c ON c.oid = a.attrelid
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+			LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE c.relkind IN ('r', 'p') AND a.attnum > 0 AND NOT a.attisdropped AND `+userSchemas+`
+		ORDER BY n.nspname, c.relname, a.attname;`)
+	columns, err := pgx.CollectRows(rows, pgx.RowToStructByPos[column])
+	if err != nil {
+		return "", fmt.Errorf("migrationstest: querying columns: %w", err)
+	}
+	rows, _ = conn.Query(ctx, `
+		SELECT n.nspname, c.relname, format('CONSTRAINT %I %s', con.conname, pg_get_constraintdef(con.oid))
+		FROM pg_constraint con
+			JOIN pg_class c ON c.oid = con.conrelid
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE `+userSchemas+`
+		ORDER BY n.nspname, c.relname, con.conname;`)
+	constraints, err := pgx.CollectRows(rows, pgx.RowToStructByPos[definition])
+	if err != nil {
+		return "", fmt.Errorf("migrationstest: querying constraints: %w", err)
+	}
+	rows, _ = conn.Query(ctx, `
+		SELECT n.nspname, t.relname, pg_get_indexdef(i.indexrelid)
+		FROM pg_index i
+			JOIN pg_class c ON c.oid = i.indexrelid
+			JOIN pg_class t ON t.oid = i.indrelid
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE `+userSchemas+`
+		ORDER BY n.nspname, c.relname;`)
+	indexes, err := pgx.CollectRows(rows, pgx.RowToStructByPos[definition])
+	if err != nil {
+		return "", fmt.Errorf("migrationstest: querying indexes: %w", err)
+	}
+	rows, _ = conn.Query(ctx, `
+		SELECT n.nspname, COALESCE(t.relname, ''), format('CREATE SEQUENCE %I.%I AS %s START %s INCREMENT %s MINVALUE %s MAXVALUE %s%s;',
+			n.nspname, c.relname, format_type(s.seqtypid, NULL), s.seqstart, s.seqincrement, s.seqmin, s.seqmax,
+			CASE WHEN s.seqcycle THEN ' CYCLE' ELSE '' END)
+		FROM pg_sequence s
+			JOIN pg_class c ON c.oid = s.seqrelid
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+			LEFT JOIN pg_depend d ON d.classid = 'pg_class'::regclass AND d.objid = c.oid
+				AND d.refclassid = 'pg_class'::regclass AND d.deptype IN ('a', 'i')
+			LEFT JOIN pg_class t ON t.oid = d.refobjid
+		WHERE `+userSchemas+`
+		ORDER BY n.nspname, c.relname;`)
+	sequences, err := pgx.CollectRows(rows, pgx.RowToStructByPos[definition])
+	if err != nil {
+		return "", fmt.Errorf("migrationstest: querying sequences: %w", err)
+	}
+	rows, _ = conn.Query(ctx, `
+		SELECT n.nspname, '', format('CREATE %sVIEW %I.%I AS%s',
+			CASE WHEN c.relkind = 'm' THEN 'MATERIALIZED ' ELSE '' END,
+			n.nspname, c.relname, pg_get_viewdef(c.oid))
+		FROM pg_class c
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('v', 'm') AND `+userSchemas+`
+		ORDER BY n.nspname, c.relname;`)
+	views, err := pgx.CollectRows(rows, pgx.RowToStructByPos[definition])
+	if err != nil {
+		return "", fmt.Errorf("migrationstest: querying views: %w", err)
+	}
+	rows, _ = conn.Query(ctx, `
+		SELECT n.nspname, '', pg_get_functiondef(p.oid)
+		FROM pg_proc p
+			JOIN pg_namespace n ON n.oid = p.pronamespace
+		WHERE p.prokind IN ('f', 'p') AND `+userSchemas+`
+			AND NOT EXISTS (SELECT 1 FROM pg_depend d WHERE d.objid = p.oid AND d.deptype = 'e')
+		ORDER BY n.nspname, p.proname, pg_get_function_identity_arguments(p.oid);`)
+	functions, err := pgx.CollectRows(rows, pgx.RowToStructByPos[definition])
+	if err != nil {
+		return "", fmt.Errorf("migrationstest: querying functions: %w", err)
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(columns); {
+		schema, table := columns[i].Schema, columns[i].Table
+		var lines []string
+		for ; i < len(columns) && columns[i].Schema == schema && columns[i].Table == table; i++ {
+			lines = append(lines, columns[i].String())
+		}
+		if options.excluded(schema, table) {
+			continue
+		}
+		for _, c := range constraints {
+			if c.Schema == schema && c.Table == table {
+				lines = append(lines, c.Definition)
+			}
+		}
+		_, _ = fmt.Fprintf(&b, "CREATE TABLE %s (\n    %s\n);\n\n", pgx.Identifier{schema, table}.Sanitize(), strings.Join(lines, ",\n    "))
+	}
+	for _, objects := range [][]definition{indexes, sequences, views, functions} {
+		var written bool
+		for _, o := range objects {
+			if options.excluded(o.Schema, o.Table) {
+				continue
+			}
+			definition := strings.TrimSpace(o.Definition)
+			if !strings.HasSuffix(definition, ";") {
+				definition += ";"
+			}
+			_, _ = fmt.Fprintln(&b, definition)
+			written = true
+		}
+		if written {
+			_, _ = fmt.Fprintln(&b)
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// String returns the column definition.
+func (c column) String() string {
+	s := pgx.Identifier{c.Name}.Sanitize() + " " + c.Type
+	if c.NotNull {
+		s += " NOT NULL"
+	}
+	if c.Default != nil {
+		s += " DEFAULT " + *c.Default
+	}
+	switch c.Identity {
+	case "a":
+		s += " GENERATED ALWAYS AS IDENTITY"
+	case "d":
+		s += " GENERATED BY DEFAULT AS IDENTITY"
+	}
+	return s
+}
@@ -0,0 +1,109 @@
+package migrations
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Direction is the direction a migration is executed.
+type Direction string
+
+const (
+	// DirectionUp is used when a migration is applied.
+	DirectionUp Direction = "up"
+	// DirectionDown is used when a migration is reverted with its down migration.
+	DirectionDown Direction = "down"
+)
+
+// MigrationStep is a migration executed on a given direction.
+type MigrationStep struct {
+	Migration MigrationDefinition
+	Direction Direction
+}
+
+// Observer is notified while the migrations are executed, see WithObserver.
+// The methods are called synchronously, so they should not block.
+// Observers implementing BackfillObserver are also notified of the
+// backfill progress.
+type Observer interface {
+	// BeforePlan is called before executing the steps, in the given order.
+	// It's called even when there is nothing to execute.
+	BeforePlan(ctx context.Context, steps []MigrationStep)
+	// BeforeMigration is called before executing a step.
+	BeforeMigration(ctx context.Context, step MigrationStep)
+	// AfterMigration is called after executing a step, err is the
+	// error returned by the migration, if any.
+	AfterMigration(ctx context.Context, step MigrationStep, duration time.Duration, err error)
+	// AfterPlan is called after the steps are executed, or one of them failed.
+	AfterPlan(ctx context.Context, duration time.Duration, err error)
+}
+
+// nopObserver is the Observer used by default.
+type nopObserver struct{}
+
+func (nopObserver) BeforePlan(context.Context, []MigrationStep)                         {}
+func (nopObserver) BeforeMigration(context.Context, MigrationStep)                      {}
+func (nopObserver) AfterMigration(context.Context, MigrationStep, time.Duration, error) {}
+func (nopObserver) AfterPlan(context.Context, time.Duration, error)                     {}
+
+// SlogObserver is an Observer that logs the migration progress with slog.
+type SlogObserver struct {
+	logger *slog.Logger
+}
+
+// NewSlogObserver returns an Observer logging to the given logger,
+// slog.Default is used when logger is nil.
+func NewSlogObserver(logger *slog.Logger) *SlogObserver {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogObserver{logger: logger}
+}
+
+// BeforePlan logs the number of steps to execute.
+func (o *SlogObserver) BeforePlan(ctx context.Context, steps []MigrationStep) {
+	o.logger.InfoContext(ctx, "migrations: starting", slog.Int("steps", len(steps)))
+}
+
+// BeforeMigration logs the migration about to be executed.
+func (o *SlogObserver) BeforeMigration(ctx context.Context, step MigrationStep) {
+	o.logger.InfoContext(ctx, "migrations: executing migration",
+		slog.String("migration", step.Migration.Name),
+		slog.String("direction", string(step.Direction)),
+	)
+}
+
+// AfterMigration logs the migration result.
+func (o *SlogObserver) AfterMigration(ctx context.Context, step MigrationStep, duration time.Duration, err error) {
+	attrs := []slog.Attr{
+		slog.String("migration", step.Migration.Name),
+		slog.String("direction", string(step.Direction)),
+		slog.Duration("duration", duration),
+	}
+	if err != nil {
+		o.logger.LogAttrs(ctx, slog.LevelError, "migrations: migration failed", append(attrs, slog.Any("error", err))...)
+		return
+	}
+	o.logger.LogAttrs(ctx, slog.LevelInfo, "migrations: migration executed", attrs...)
+}
+
+// BackfillProgress logs the backfill progress.
+func (o *SlogObserver) BackfillProgress(ctx context.Context, migration MigrationDefinition, progress BackfillProgress) {
+	o.logger.InfoContext(ctx, "migrations: backfill progress",
+		slog.String("migration", migration.Name),
+		slog.Int64("next", progress.Next),
+		slog.Int64("max_key", progress.MaxKey),
+		slog.Int("batches", progress.Batches),
+		slog.Int64("rows", progress.Rows),
+	)
+}
+
+// AfterPlan logs the plan result.
+func (o *SlogObserver) AfterPlan(ctx context.Context, duration time.Duration, err error) {
+	if err != nil {
+		o.logger.ErrorContext(ctx, "migrations: failed", slog.Duration("duration", duration), slog.Any("error", err))
+		return
+	}
+	o.logger.InfoContext(ctx, "migrations: completed", slog.Duration("duration", duration))
+}
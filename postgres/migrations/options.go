@@ -0,0 +1,239 @@
+package migrations
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Option is the signature of options that can be provided to Migrate and friends.
+type Option func(opts *options)
+
+// WithRepair is an option that re-stamps the checksum of applied migrations
+// whose content changed, instead of failing with a *DriftError.
+func WithRepair() Option {
+	return func(opts *options) {
+		opts.repair = true
+	}
+}
+
+// WithLockKey is an option that allows providing the key of the
+// advisory lock held while migrating.
+func WithLockKey(key int64) Option {
+	return func(opts *options) {
+		opts.lockKey = &key
+	}
+}
+
+// WithLockTimeout is an option that allows providing the maximum time
+// to wait for the advisory lock, zero means waiting until the context is done.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(opts *options) {
+		opts.lockTimeout = timeout
+	}
+}
+
+// WithoutLock is an option that disables the advisory lock.
+// It should only be used when the caller already guarantees a single migrator.
+func WithoutLock() Option {
+	return func(opts *options) {
+		opts.lockDisabled = true
+	}
+}
+
+// WithStrict is an option that makes Migrate fail with an *OrderError when
+// a pending migration is older than the newest applied migration or when an
+// applied migration is not part of the plan.
+func WithStrict() Option {
+	return func(opts *options) {
+		opts.strict = true
+	}
+}
+
+// WithGoMigrations is an option that registers migrations implemented in Go,
+// they are merged with the migration files by version.
+func WithGoMigrations(migrations ...GoMigration) Option {
+	return func(opts *options) {
+		opts.goMigrations = append(opts.goMigrations, migrations...)
+	}
+}
+
+// WithBackfills registers batched data backfills, applied with the other
+// migrations by version, see Backfill.
+func WithBackfills(backfills ...Backfill) Option {
+	return func(opts *options) {
+		opts.backfills = append(opts.backfills, backfills...)
+	}
+}
+
+// WithTableName is an option that allows providing the name of the migration table.
+func WithTableName(name string) Option {
+	return func(opts *options) {
+		opts.tableName = name
+	}
+}
+
+// WithSchema is an option that allows providing the schema of the migration table,
+// by default the table is created on the current search_path.
+func WithSchema(schema string) Option {
+	return func(opts *options) {
+		opts.schema = schema
+	}
+}
+
+// WithConcurrency is an option that allows providing how many schemas
+// MigrateTenants migrates at the same time, by default one at a time.
+func WithConcurrency(n int) Option {
+	return func(opts *options) {
+		opts.concurrency = n
+	}
+}
+
+// WithSource adds a directory of migrations, walked recursively, to the plan.
+// The migrations are named by their path prefixed with the namespace, and
+// are ordered with the other sources by version. Versions must be unique
+// only within a namespace. It allows modules to ship their own migrations,
+// e.g. embedded with embed.FS.
+func WithSource(namespace string, fsys fs.FS) Option {
+	return func(opts *options) {
+		opts.sources = append(opts.sources, source{namespace: namespace, fsys: fsys})
+	}
+}
+
+// WithAppVersion sets the application version recorded with each
+// migration applied.
+func WithAppVersion(version string) Option {
+	return func(opts *options) {
+		opts.appVersion = version
+	}
+}
+
+// WithPhase applies only the migrations of the deploy phase: PhaseExpand
+// skips the contract migrations, PhaseContract applies only the contract
+// migrations, failing while expand migrations are pending.
+// Repeatable migrations are applied on the expand phase, an unknown phase
+// is an error.
+func WithPhase(phase Phase) Option {
+	return func(opts *options) {
+		opts.phase = phase
+	}
+}
+
+// WithSeeds applies the seeds of the given environments, the subdirectories
+// of seedsDir, after the migrations are applied, see Seed.
+// Seeds are only applied when migrating to the latest version,
+// and not on the contract phase.
+func WithSeeds(seedsDir fs.FS, environments ...string) Option {
+	return func(opts *options) {
+		opts.seeds = &seeds{fsys: seedsDir, environments: environments}
+	}
+}
+
+// WithObserver sets the observer notified while the migrations are executed,
+// see NewSlogObserver for an implementation based on slog.
+func WithObserver(observer Observer) Option {
+	return func(opts *options) {
+		opts.observer = observer
+	}
+}
+
+// options holds references for all the options we allow providing on Migrate.
+type options struct {
+	repair       bool
+	strict       bool
+	lockKey      *int64
+	lockTimeout  time.Duration
+	lockDisabled bool
+	goMigrations []GoMigration
+	tableName    string
+	schema       string
+	concurrency  int
+	observer     Observer
+	sources      []source
+	appVersion   string
+	phase        Phase
+	seeds        *seeds
+	backfills    []Backfill
+}
+
+// table returns the identifier of the migration table.
+func (o *options) table() pgx.Identifier {
+	if o.schema == "" {
+		return pgx.Identifier{o.tableName}
+	}
+	return pgx.Identifier{o.schema, o.tableName}
+}
+
+// seedTable returns the identifier of the seed table.
+func (o *options) seedTable() pgx.Identifier {
+	table := o.table()
+	table[len(table)-1] += "_seeds"
+	return table
+}
+
+// backfillTable returns the identifier of the backfill checkpoint table.
+func (o *options) backfillTable() pgx.Identifier {
+	table := o.table()
+	table[len(table)-1] += "_backfills"
+	return table
+}
+
+// HistoryTables returns the tables used to record the migrations applied
+// with the given options: the migration table and the seed and backfill tables.
+func HistoryTables(opts ...Option) []pgx.Identifier {
+	options := newOptions(opts)
+	return []pgx.Identifier{options.table(), options.seedTable(), options.backfillTable()}
+}
+
+// migrationPlan builds the migration plan of the migrations directory,
+// merged with the sources and the Go migrations.
+func (o *options) migrationPlan(migrationsDir fs.FS) ([]MigrationDefinition, error) {
+	sources := append([]source{{fsys: migrationsDir}}, o.sources...)
+	for _, s := range o.sources {
+		if s.namespace == "" || !fs.ValidPath(s.namespace) || s.namespace == "." {
+			return nil, fmt.Errorf("invalid source namespace %q", s.namespace)
+		}
+	}
+	definitions := make([]MigrationDefinition, 0, len(o.goMigrations)+len(o.backfills))
+	for _, g := range o.goMigrations {
+		if err := g.validate(); err != nil {
+			return nil, err
+		}
+		definitions = append(definitions, g.definition())
+	}
+	for _, b := range o.backfills {
+		if err := b.validate(); err != nil {
+			return nil, err
+		}
+		definitions = append(definitions, b.definition())
+	}
+	return buildSourcesPlan(sources, definitions)
+}
+
+// advisoryLockKey returns the key of the advisory lock held while migrating,
+// by default it's derived from the migration table, so migrations
+// using different tables do not block each other.
+func (o *options) advisoryLockKey() int64 {
+	if o.lockKey != nil {
+		return *o.lockKey
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(o.table().Sanitize()))
+	return int64(h.Sum64())
+}
+
+// newOptions returns the options with the defaults and the given options applied.
+func newOptions(opts []Option) *options {
+	defaultOpts := &options{
+		tableName:   "migrations",
+		concurrency: 1,
+		observer:    nopObserver{},
+	}
+	for _, opt := range opts {
+		opt(defaultOpts)
+	}
+	return defaultOpts
+}
@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_options_table(t *testing.T) {
+	t.Parallel()
+	require.Equal(t, pgx.Identifier{"migrations"}, newOptions(nil).table())
+	require.Equal(t, pgx.Identifier{"meta", "history"}, newOptions([]Option{
+		WithTableName("history"),
+		WithSchema("meta"),
+	}).table())
+}
+
+func TestHistoryTables(t *testing.T) {
+	t.Parallel()
+	require.Equal(t, []pgx.Identifier{{"migrations"}, {"migrations_seeds"}, {"migrations_backfills"}}, HistoryTables())
+	require.Equal(t, []pgx.Identifier{{"meta", "history"}, {"meta", "history_seeds"}, {"meta", "history_backfills"}}, HistoryTables(
+		WithTableName("history"),
+		WithSchema("meta"),
+	))
+}
+
+func Test_options_advisoryLockKey(t *testing.T) {
+	t.Parallel()
+	defaultKey := newOptions(nil).advisoryLockKey()
+	require.Equal(t, defaultKey, newOptions(nil).advisoryLockKey())
+	require.NotEqual(t, defaultKey, newOptions([]Option{WithSchema("tenant_a")}).advisoryLockKey())
+	require.Equal(t, int64(42), newOptions([]Option{WithSchema("tenant_a"), WithLockKey(42)}).advisoryLockKey())
+}
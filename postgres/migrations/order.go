@@ -0,0 +1,64 @@
+package migrations
+
+import (
+	"strings"
+)
+
+// OrderError is returned on strict mode when the applied migrations
+// do not match the order of the plan.
+type OrderError struct {
+	// OutOfOrder are the pending migrations older than the newest applied migration.
+	OutOfOrder []string
+	// Unknown are the applied migrations that are not part of the plan.
+	Unknown []string
+}
+
+// Error implements the error interface.
+func (e *OrderError) Error() string {
+	var parts []string
+	if len(e.OutOfOrder) > 0 {
+		parts = append(parts, "out of order: "+strings.Join(e.OutOfOrder, ", "))
+	}
+	if len(e.Unknown) > 0 {
+		parts = append(parts, "unknown applied: "+strings.Join(e.Unknown, ", "))
+	}
+	return "migrations: invalid migration order: " + strings.Join(parts, "; ")
+}
+
+// verifyOrder checks that every pending migration comes after the applied
+// migrations and that every applied migration is part of the plan.
+// Pending contract migrations are expected to be older than the expand
+// migrations applied after them, so they are not reported.
+func verifyOrder(plan []MigrationDefinition, applied []appliedMigration) error {
+	var (
+		orderErr    OrderError
+		lastApplied = -1
+		appliedIdx  = make(map[string]struct{}, len(applied))
+	)
+	for _, a := range applied {
+		appliedIdx[a.Name] = struct{}{}
+	}
+	for i, definition := range plan {
+		if _, ok := appliedIdx[definition.Name]; ok {
+			if !definition.Repeatable {
+				lastApplied = i
+			}
+			delete(appliedIdx, definition.Name)
+		}
+	}
+	for _, definition := range pendingMigrations(plan[:lastApplied+1], applied) {
+		if definition.Phase == PhaseContract {
+			continue
+		}
+		orderErr.OutOfOrder = append(orderErr.OutOfOrder, definition.Name)
+	}
+	for _, a := range applied {
+		if _, ok := appliedIdx[a.Name]; ok {
+			orderErr.Unknown = append(orderErr.Unknown, a.Name)
+		}
+	}
+	if len(orderErr.OutOfOrder) > 0 || len(orderErr.Unknown) > 0 {
+		return &orderErr
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package migrations
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Phase is the phase of a zero-downtime deploy a migration belongs to.
+// Changes like renaming a column are split into an expand migration, adding
+// the new column while keeping the old one, and a contract migration,
+// dropping the old column once no application version uses it.
+type Phase string
+
+const (
+	// PhaseExpand is the phase of backward compatible migrations, applied
+	// before the new application version is rolled out.
+	// Migrations without a phase belong to it.
+	PhaseExpand Phase = "expand"
+	// PhaseContract is the phase of migrations that break the previous
+	// application version, applied after the rollout completes.
+	PhaseContract Phase = "contract"
+)
+
+// ParsePhase parses the phase from a directive value or a command line flag.
+func ParsePhase(value string) (Phase, error) {
+	switch phase := Phase(value); phase {
+	case PhaseExpand, PhaseContract:
+		return phase, nil
+	}
+	return "", fmt.Errorf("invalid phase %q", value)
+}
+
+// migrationPhase returns the migration phase given the attributes.
+func migrationPhase(attributes []string) Phase {
+	if slices.Contains(attributes, string(PhaseContract)) {
+		return PhaseContract
+	}
+	return PhaseExpand
+}
+
+// phaseMigrations returns the pending migrations applied on the phase.
+// The expand phase skips the contract migrations, the contract phase
+// applies only contract migrations and fails while expand migrations are pending.
+// Every migration is applied without a phase, an unknown phase is an error.
+func phaseMigrations(pending []MigrationDefinition, phase Phase) ([]MigrationDefinition, error) {
+	if phase == "" {
+		return pending, nil
+	}
+	if _, err := ParsePhase(string(phase)); err != nil {
+		return nil, fmt.Errorf("migrations: %w", err)
+	}
+	var (
+		migrations    []MigrationDefinition
+		pendingExpand []string
+	)
+	for _, p := range pending {
+		if p.Phase == phase {
+			migrations = append(migrations, p)
+		} else if p.Phase == PhaseExpand {
+			pendingExpand = append(pendingExpand, p.Name)
+		}
+	}
+	if phase == PhaseContract && len(pendingExpand) > 0 {
+		return nil, fmt.Errorf("migrations: expand migrations pending before the contract phase: %s", strings.Join(pendingExpand, ", "))
+	}
+	return migrations, nil
+}
@@ -0,0 +1,152 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+
+	"github.com/crossworth/pkgs/postgres/xpgx"
+	"github.com/jackc/pgx/v5"
+)
+
+// seeds is a directory of seeds and the environments applied from it, see WithSeeds.
+type seeds struct {
+	fsys         fs.FS
+	environments []string
+}
+
+// buildSeedPlan returns the seeds of the environments, in the given order,
+// the files of each environment directory are walked recursively and
+// sorted by path. Seeds are named <environment>/<path>.
+func buildSeedPlan(seedsDir fs.FS, environments []string) ([]MigrationDefinition, error) {
+	var plan []MigrationDefinition
+	for _, environment := range environments {
+		if !fs.ValidPath(environment) || environment == "." {
+			return nil, fmt.Errorf("invalid seed environment %q", environment)
+		}
+		dir, err := fs.Sub(seedsDir, environment)
+		if err != nil {
+			return nil, fmt.Errorf("reading seeds of %s: %w", environment, err)
+		}
+		files, err := sourceFiles(dir)
+		if err != nil {
+			return nil, fmt.Errorf("reading seeds of %s: %w", environment, err)
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			name := path.Join(environment, f)
+			if path.Ext(f) != ".sql" {
+				return nil, fmt.Errorf("invalid seed name %s: missing .sql extension", name)
+			}
+			contents, err := fs.ReadFile(dir, f)
+			if err != nil {
+				return nil, fmt.Errorf("reading file %s: %w", name, err)
+			}
+			definition := MigrationDefinition{
+				Name:                 name,
+				Content:              string(contents),
+				RunInsideTransaction: isMigrationTx(migrationAttributes(path.Base(f))),
+			}
+			if err := applyDirectives(&definition); err != nil {
+				return nil, fmt.Errorf("parsing directives of %s: %w", name, err)
+			}
+			plan = append(plan, definition)
+		}
+	}
+	return plan, nil
+}
+
+// createSeedTable creates the seed history table.
+func createSeedTable(ctx context.Context, conn xpgx.Executable, table pgx.Identifier) error {
+	if _, err := conn.Exec(ctx, `
+CREATE TABLE IF NOT EXISTS `+table.Sanitize()+` (
+	id SERIAL,
+	name CHARACTER VARYING NOT NULL,
+	checksum CHARACTER VARYING NOT NULL,
+	applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW() NOT NULL
+);`); err != nil {
+		return fmt.Errorf("creating seed table: %w", err)
+	}
+	return nil
+}
+
+// appliedSeeds returns the checksum of the seeds applied by name.
+func appliedSeeds(ctx context.Context, conn xpgx.Queryable, table pgx.Identifier) (map[string]string, error) {
+	rows, _ := conn.Query(ctx, `SELECT name, checksum FROM `+table.Sanitize()+` ORDER BY id;`)
+	applied := make(map[string]string)
+	var name, checksum string
+	if _, err := pgx.ForEachRow(rows, []any{&name, &checksum}, func() error {
+		applied[name] = checksum
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("querying for applied seeds: %w", err)
+	}
+	return applied, nil
+}
+
+// recordSeed replaces the record of a seed on the seed table.
+func recordSeed(ctx context.Context, conn xpgx.Executable, table pgx.Identifier, definition MigrationDefinition) error {
+	if _, err := conn.Exec(ctx, `DELETE FROM `+table.Sanitize()+` WHERE name = $1;`, definition.Name); err != nil {
+		return fmt.Errorf("deleting seed record: %w", err)
+	}
+	if _, err := conn.Exec(ctx, `INSERT INTO `+table.Sanitize()+` (name, checksum) VALUES ($1, $2);`, definition.Name, definition.Checksum()); err != nil {
+		return fmt.Errorf("inserting seed record: %w", err)
+	}
+	return nil
+}
+
+// applySeed applies a seed and records it.
+func applySeed(ctx context.Context, conn xpgx.Connection, table pgx.Identifier, definition MigrationDefinition) error {
+	if !definition.RunInsideTransaction {
+		if err := execMigration(ctx, conn, definition); err != nil {
+			return err
+		}
+		return recordSeed(ctx, conn, table, definition)
+	}
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if err := execMigration(ctx, tx, definition); err != nil {
+			return err
+		}
+		return recordSeed(ctx, tx, table, definition)
+	})
+}
+
+// applySeeds applies the seeds not applied yet or changed since applied.
+func applySeeds(ctx context.Context, conn xpgx.Connection, options *options) error {
+	plan, err := buildSeedPlan(options.seeds.fsys, options.seeds.environments)
+	if err != nil {
+		return fmt.Errorf("migrations: building seed plan: %w", err)
+	}
+	if err := createSeedTable(ctx, conn, options.seedTable()); err != nil {
+		return fmt.Errorf("migrations: %w", err)
+	}
+	applied, err := appliedSeeds(ctx, conn, options.seedTable())
+	if err != nil {
+		return fmt.Errorf("migrations: %w", err)
+	}
+	for _, definition := range plan {
+		if checksum, ok := applied[definition.Name]; ok && checksum == definition.Checksum() {
+			continue
+		}
+		if err := applySeed(ctx, conn, options.seedTable(), definition); err != nil {
+			return fmt.Errorf("migrations: applying seed %s: %w", definition.Name, err)
+		}
+	}
+	return nil
+}
+
+// Seed applies the seeds of the given environments, the subdirectories of
+// seedsDir, in order. Seeds not applied yet, or changed since they were
+// applied, are executed and recorded on the seed table, named after the
+// migration table with the _seeds suffix.
+// Since changed seeds run again, they should be written to be re-applied,
+// e.g. using INSERT ... ON CONFLICT.
+// Seeds accept the same directives of the migrations and the no-tx attribute.
+func Seed(ctx context.Context, conn xpgx.Connection, seedsDir fs.FS, environments []string, opts ...Option) error {
+	options := newOptions(append(opts, WithSeeds(seedsDir, environments...)))
+	return withLock(ctx, conn, options, func(conn xpgx.Connection) error {
+		return applySeeds(ctx, conn, options)
+	})
+}
@@ -0,0 +1,141 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StatementError is returned when a statement of a migration executed
+// outside a transaction fails, the statements before it were applied.
+type StatementError struct {
+	// Index is the zero based index of the statement that failed,
+	// it's also the number of statements applied.
+	Index     int
+	Statement string
+	Err       error
+}
+
+// Error implements the error interface.
+func (e *StatementError) Error() string {
+	return fmt.Sprintf("executing statement %d: %s", e.Index+1, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *StatementError) Unwrap() error {
+	return e.Err
+}
+
+// isIdentifierChar check if the byte can be part of an identifier.
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
+
+// dollarQuoteTag returns the dollar quote tag ($tag$ or $$) starting at i,
+// or an empty string when there is no dollar quote at i.
+func dollarQuoteTag(sql string, i int) string {
+	if sql[i] != '$' || (i > 0 && isIdentifierChar(sql[i-1])) {
+		return ""
+	}
+	for j := i + 1; j < len(sql); j++ {
+		c := sql[j]
+		switch {
+		case c == '$':
+			return sql[i : j+1]
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80:
+		case c >= '0' && c <= '9' && j > i+1:
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+// splitStatements splits the SQL into individual statements.
+// It understands quoted strings (including E'\n' escape strings), quoted
+// identifiers, line and nested block comments and dollar quoting, so
+// semicolons inside them do not end a statement.
+// Statements are returned without the terminating semicolon and statements
+// containing only comments are omitted.
+func splitStatements(sql string) []string {
+	var (
+		statements []string
+		start      int
+		hasCode    bool
+	)
+	flush := func(end int) {
+		if statement := strings.TrimSpace(sql[start:end]); hasCode && statement != "" {
+			statements = append(statements, statement)
+		}
+		hasCode = false
+	}
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end == -1 {
+				i = len(sql)
+				continue
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			depth := 0
+			for ; i < len(sql); i++ {
+				if strings.HasPrefix(sql[i:], "/*") {
+					depth++
+					i++
+				} else if strings.HasPrefix(sql[i:], "*/") {
+					depth--
+					i++
+					if depth == 0 {
+						break
+					}
+				}
+			}
+		case c == '\'':
+			hasCode = true
+			escapes := i > 0 && (sql[i-1] == 'e' || sql[i-1] == 'E') && (i == 1 || !isIdentifierChar(sql[i-2]))
+			for i++; i < len(sql); i++ {
+				if escapes && sql[i] == '\\' {
+					i++
+					continue
+				}
+				if sql[i] == '\'' {
+					if i+1 < len(sql) && sql[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+		case c == '"':
+			hasCode = true
+			for i++; i < len(sql); i++ {
+				if sql[i] == '"' {
+					if i+1 < len(sql) && sql[i+1] == '"' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+		case c == '$' && dollarQuoteTag(sql, i) != "":
+			hasCode = true
+			tag := dollarQuoteTag(sql, i)
+			end := strings.Index(sql[i+len(tag):], tag)
+			if end == -1 {
+				i = len(sql)
+				continue
+			}
+			i += len(tag) + end + len(tag) - 1
+		case c == ';':
+			flush(i)
+			start = i + 1
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		default:
+			hasCode = true
+		}
+	}
+	flush(len(sql))
+	return statements
+}
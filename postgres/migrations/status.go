@@ -0,0 +1,166 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"slices"
+	"time"
+
+	"github.com/crossworth/pkgs/postgres/xpgx"
+	"github.com/jackc/pgx/v5"
+)
+
+// MigrationState is the state of a migration on the database.
+type MigrationState string
+
+const (
+	// StateApplied is the state of a migration already applied.
+	StateApplied MigrationState = "applied"
+	// StatePending is the state of a migration not applied yet.
+	StatePending MigrationState = "pending"
+	// StateMissing is the state of an applied migration whose file no longer exists.
+	StateMissing MigrationState = "missing"
+	// StateDirty is the state of a migration that started but did not complete.
+	StateDirty MigrationState = "dirty"
+)
+
+// MigrationStatus is the status of a migration on the database.
+type MigrationStatus struct {
+	// Definition is the migration definition, only the Name is
+	// filled for missing migrations.
+	Definition MigrationDefinition
+	State      MigrationState
+	// AppliedAt is when the migration was applied, nil for pending migrations.
+	AppliedAt *time.Time
+}
+
+// tableExists check if the migration table exists.
+func tableExists(ctx context.Context, conn xpgx.Queryable, table pgx.Identifier) (bool, error) {
+	rows, _ := conn.Query(ctx, `SELECT to_regclass($1) IS NOT NULL;`, table.Sanitize())
+	exists, err := pgx.CollectOneRow(rows, pgx.RowTo[bool])
+	if err != nil {
+		return false, fmt.Errorf("checking migration table: %w", err)
+	}
+	return exists, nil
+}
+
+// tableColumns returns the column names of the migration table.
+func tableColumns(ctx context.Context, conn xpgx.Queryable, table pgx.Identifier) ([]string, error) {
+	rows, _ := conn.Query(ctx, `SELECT attname FROM pg_attribute WHERE attrelid = to_regclass($1) AND attnum > 0 AND NOT attisdropped;`, table.Sanitize())
+	columns, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("querying migration table columns: %w", err)
+	}
+	return columns, nil
+}
+
+// readAppliedMigrations returns the applied migrations without creating
+// or upgrading the migration table, so it can be used on read only inspections.
+// Tables created by older versions, without the checksum and dirty columns,
+// have their migrations returned without checksum and not dirty.
+func readAppliedMigrations(ctx context.Context, conn xpgx.Queryable, table pgx.Identifier) ([]appliedMigration, error) {
+	exists, err := tableExists(ctx, conn, table)
+	if err != nil || !exists {
+		return nil, err
+	}
+	columns, err := tableColumns(ctx, conn, table)
+	if err != nil {
+		return nil, err
+	}
+	checksum, dirty := "checksum", "dirty"
+	if !slices.Contains(columns, checksum) {
+		checksum = "NULL::CHARACTER VARYING"
+	}
+	if !slices.Contains(columns, dirty) {
+		dirty = "FALSE"
+	}
+	rows, _ := conn.Query(ctx, `SELECT name, `+checksum+`, applied_at, `+dirty+` FROM `+table.Sanitize()+` ORDER BY id;`)
+	applied, err := pgx.CollectRows(rows, pgx.RowToStructByPos[appliedMigration])
+	if err != nil {
+		return nil, fmt.Errorf("querying for applied migrations: %w", err)
+	}
+	return applied, nil
+}
+
+// Status returns the status of every migration, in the plan order followed
+// by the applied migrations missing from the plan.
+// Contract migrations still pending have the StatePending state and
+// the PhaseContract phase on the definition.
+// It does not change the database.
+func Status(ctx context.Context, conn xpgx.Connection, migrationsDir fs.FS, opts ...Option) ([]MigrationStatus, error) {
+	options := newOptions(opts)
+	migrationPlan, err := options.migrationPlan(migrationsDir)
+	if err != nil {
+		return nil, fmt.Errorf("migrations: building migration plan: %w", err)
+	}
+	applied, err := readAppliedMigrations(ctx, conn, options.table())
+	if err != nil {
+		return nil, fmt.Errorf("migrations: querying for applied migrations: %w", err)
+	}
+	applied, baseline := resolveBaseline(migrationPlan, applied)
+	if baseline != nil {
+		applied = append(applied, *baseline)
+	}
+	appliedByName := make(map[string]appliedMigration, len(applied))
+	for _, a := range applied {
+		appliedByName[a.Name] = a
+	}
+	status := make([]MigrationStatus, 0, len(migrationPlan))
+	for _, definition := range migrationPlan {
+		a, ok := appliedByName[definition.Name]
+		if !ok {
+			status = append(status, MigrationStatus{Definition: definition, State: StatePending})
+			continue
+		}
+		delete(appliedByName, definition.Name)
+		state := StateApplied
+		switch {
+		case a.Dirty:
+			state = StateDirty
+		case definition.Repeatable && valueOrEmpty(a.Checksum) != definition.Checksum():
+			// changed repeatable migrations are applied again
+			state = StatePending
+		}
+		status = append(status, MigrationStatus{Definition: definition, State: state, AppliedAt: &a.AppliedAt})
+	}
+	for _, a := range applied {
+		if _, ok := appliedByName[a.Name]; !ok {
+			continue
+		}
+		status = append(status, MigrationStatus{
+			Definition: MigrationDefinition{Name: a.Name},
+			State:      StateMissing,
+			AppliedAt:  &a.AppliedAt,
+		})
+	}
+	return status, nil
+}
+
+// Plan returns the migrations that Migrate would apply, in order, honoring WithPhase,
+// the SQL executed by each migration is available on the Content field,
+// Go migrations have the Func field set instead.
+// It does not change the database.
+func Plan(ctx context.Context, conn xpgx.Connection, migrationsDir fs.FS, opts ...Option) ([]MigrationDefinition, error) {
+	options := newOptions(opts)
+	migrationPlan, err := options.migrationPlan(migrationsDir)
+	if err != nil {
+		return nil, fmt.Errorf("migrations: building migration plan: %w", err)
+	}
+	applied, err := readAppliedMigrations(ctx, conn, options.table())
+	if err != nil {
+		return nil, fmt.Errorf("migrations: querying for applied migrations: %w", err)
+	}
+	applied, baseline := resolveBaseline(migrationPlan, applied)
+	if baseline != nil {
+		applied = append(applied, *baseline)
+	}
+	pending, err := phaseMigrations(pendingMigrations(migrationPlan, applied), options.phase)
+	if err != nil {
+		return nil, err
+	}
+	if options.phase == PhaseContract {
+		return pending, nil
+	}
+	return append(pending, pendingRepeatables(migrationPlan, applied)...), nil
+}
@@ -0,0 +1,100 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/crossworth/pkgs/postgres/xpgx"
+	"github.com/jackc/pgx/v5"
+)
+
+// TenantResult is the result of migrating a tenant schema.
+type TenantResult struct {
+	Schema string
+	Err    error
+}
+
+// setSearchPath sets the session search_path to the given schema.
+func setSearchPath(ctx context.Context, conn xpgx.Executable, schema string) error {
+	if _, err := conn.Exec(ctx, `SELECT set_config('search_path', $1, false);`, pgx.Identifier{schema}.Sanitize()); err != nil {
+		return fmt.Errorf("setting search_path: %w", err)
+	}
+	return nil
+}
+
+// resetSearchPath resets the session search_path to the default value.
+func resetSearchPath(ctx context.Context, conn xpgx.Executable) error {
+	if _, err := conn.Exec(ctx, `RESET search_path;`); err != nil {
+		return fmt.Errorf("resetting search_path: %w", err)
+	}
+	return nil
+}
+
+// migrateTenant migrates a single tenant schema, setting the search_path
+// to the schema while the migrations are applied.
+func migrateTenant(ctx context.Context, conn xpgx.Connection, migrationsDir fs.FS, schema string, opts []Option) (err error) {
+	if pool, ok := conn.(xpgx.Acquirable); ok {
+		c, err := pool.Acquire(ctx)
+		if err != nil {
+			return fmt.Errorf("migrations: acquiring connection: %w", err)
+		}
+		defer c.Release()
+		conn = c
+	}
+	if err := setSearchPath(ctx, conn, schema); err != nil {
+		return fmt.Errorf("migrations: %w", err)
+	}
+	defer func() {
+		if resetErr := resetSearchPath(context.WithoutCancel(ctx), conn); resetErr != nil {
+			err = errors.Join(err, fmt.Errorf("migrations: %w", resetErr))
+		}
+	}()
+	return MigrateTo(ctx, conn, migrationsDir, math.MaxInt64, tenantOptions(opts, schema)...)
+}
+
+// tenantOptions returns the options used to migrate the tenant schema,
+// always on a new slice, as the tenants are migrated concurrently and
+// appending to opts could share its backing array.
+func tenantOptions(opts []Option, schema string) []Option {
+	return append(slices.Clip(opts), WithSchema(schema))
+}
+
+// MigrateTenants applies the migrations to each one of the given schemas.
+// The migration table is created on each schema and the search_path is set
+// to the schema while its migrations are applied, so unqualified objects
+// are created on the tenant schema.
+// Schemas are migrated one at a time unless WithConcurrency is provided and
+// conn is a pool, a result is returned for each schema, in the given order.
+func MigrateTenants(ctx context.Context, conn xpgx.Connection, migrationsDir fs.FS, schemas []string, opts ...Option) []TenantResult {
+	options := newOptions(opts)
+	concurrency := max(options.concurrency, 1)
+	if _, ok := conn.(xpgx.Acquirable); !ok {
+		concurrency = 1
+	}
+	var (
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, concurrency)
+		results   = make([]TenantResult, len(schemas))
+	)
+	for i, schema := range schemas {
+		results[i].Schema = schema
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func() {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			if err := migrateTenant(ctx, conn, migrationsDir, schema, opts); err != nil {
+				results[i].Err = fmt.Errorf("migrations: migrating schema %s: %w", schema, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
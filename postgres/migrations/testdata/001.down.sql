@@ -0,0 +1 @@
+drop table users;
\ No newline at end of file
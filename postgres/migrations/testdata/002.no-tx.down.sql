@@ -0,0 +1 @@
+DROP INDEX CONCURRENTLY users_name_idx;
\ No newline at end of file
@@ -0,0 +1 @@
+drop table records;
\ No newline at end of file
@@ -5,6 +5,7 @@ package xpgx
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/crossworth/pkgs/xerror"
@@ -61,22 +62,137 @@ type transactionKey struct{}
 
 // IsWithinTransaction returns true if we are inside a transaction.
 func IsWithinTransaction(ctx context.Context) bool {
-	inside, ok := ctx.Value(transactionKey{}).(bool)
-	return ok && inside
+	_, ok := ctx.Value(transactionKey{}).(pgx.Tx)
+	return ok
+}
+
+// ErrStricterTransaction is returned when a nested call of WithinTransaction
+// requires a stricter isolation level than the outer transaction, or requires
+// read only access inside a read write transaction.
+var ErrStricterTransaction = errors.New("xpgx: nested transaction is stricter than the outer transaction")
+
+// TxBeginner is implemented by connections able to begin transactions
+// with options, like *pgx.Conn and *pgxpool.Pool.
+type TxBeginner interface {
+	BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+}
+
+// TransactionOption is the signature of options that can be provided to WithinTransaction.
+type TransactionOption func(opts *transactionOptions)
+
+// WithFlattenedNesting is an option that makes a nested call reuse the outer
+// transaction instead of creating a save point, so an error on the nested call
+// is only rolled back with the outer transaction.
+func WithFlattenedNesting() TransactionOption {
+	return func(opts *transactionOptions) {
+		opts.flatten = true
+	}
+}
+
+// WithIsolationLevel is an option that allows providing the transaction isolation level.
+func WithIsolationLevel(level pgx.TxIsoLevel) TransactionOption {
+	return func(opts *transactionOptions) {
+		opts.txOptions.IsoLevel = level
+	}
+}
+
+// WithReadOnly is an option that makes the transaction read only.
+func WithReadOnly() TransactionOption {
+	return func(opts *transactionOptions) {
+		opts.txOptions.AccessMode = pgx.ReadOnly
+	}
+}
+
+// WithDeferrable is an option that makes the transaction deferrable, it only
+// has effect on serializable read only transactions, which wait to run
+// without serialization failures.
+func WithDeferrable() TransactionOption {
+	return func(opts *transactionOptions) {
+		opts.txOptions.DeferrableMode = pgx.Deferrable
+	}
+}
+
+// transactionOptions holds references for all the options we allow providing on WithinTransaction.
+type transactionOptions struct {
+	flatten   bool
+	txOptions pgx.TxOptions
+}
+
+// isolationStrictness returns how strict an isolation level is,
+// read uncommitted behaves like read committed on Postgres.
+func isolationStrictness(level pgx.TxIsoLevel) int {
+	switch level {
+	case pgx.RepeatableRead:
+		return 1
+	case pgx.Serializable:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// checkNestedTransaction checks the outer transaction satisfies the options
+// of a nested call. The isolation level and access mode are read from the outer
+// transaction, as they may come from the server defaults.
+func checkNestedTransaction(ctx context.Context, outer pgx.Tx, txOptions pgx.TxOptions) error {
+	if txOptions.IsoLevel == "" && txOptions.AccessMode != pgx.ReadOnly {
+		return nil
+	}
+	var (
+		isoLevel string
+		readOnly bool
+	)
+	err := outer.QueryRow(ctx, `SELECT current_setting('transaction_isolation'), current_setting('transaction_read_only')::BOOLEAN;`).Scan(&isoLevel, &readOnly)
+	if err != nil {
+		return fmt.Errorf("xpgx: reading outer transaction settings: %w", err)
+	}
+	if isolationStrictness(txOptions.IsoLevel) > isolationStrictness(pgx.TxIsoLevel(isoLevel)) {
+		return fmt.Errorf("%w: isolation level %q inside %q", ErrStricterTransaction, txOptions.IsoLevel, isoLevel)
+	}
+	if txOptions.AccessMode == pgx.ReadOnly && !readOnly {
+		return fmt.Errorf("%w: read only inside a read write transaction", ErrStricterTransaction)
+	}
+	return nil
 }
 
 // WithinTransaction executes the given function inside a transaction.
-// It's important to note that we do not support nested transactions (save points).
-func WithinTransaction(ctx context.Context, conn Connection, inTransaction func(ctx context.Context) error) error {
+// A nested call executes the function inside a save point of the outer
+// transaction, when the function returns an error only the changes made
+// after the save point are rolled back, and the outer transaction can continue.
+// See WithFlattenedNesting to reuse the outer transaction instead.
+// The transaction options are only applied by the outermost call, which
+// requires a connection implementing TxBeginner. A save point can't change
+// them, so nested calls fail with ErrStricterTransaction when they require a
+// stricter isolation level or read only access, and inherit the deferrable mode.
+func WithinTransaction(ctx context.Context, conn Connection, inTransaction func(ctx context.Context) error, opts ...TransactionOption) error {
+	options := &transactionOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
 	// already in a transaction
-	if IsWithinTransaction(ctx) {
-		return inTransaction(ctx)
+	if outer, ok := ctx.Value(transactionKey{}).(pgx.Tx); ok {
+		if err := checkNestedTransaction(ctx, outer, options.txOptions); err != nil {
+			return err
+		}
+		if options.flatten {
+			return inTransaction(ctx)
+		}
+		// Begin on a transaction creates a save point
+		conn = outer
 	}
-	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+	fn := func(tx pgx.Tx) error {
 		ctx = SetConnectionOnContext(ctx, tx)
-		ctx = context.WithValue(ctx, transactionKey{}, true)
+		ctx = context.WithValue(ctx, transactionKey{}, tx)
 		return inTransaction(ctx)
-	})
+	}
+	if IsWithinTransaction(ctx) || options.txOptions == (pgx.TxOptions{}) {
+		return pgx.BeginFunc(ctx, conn, fn)
+	}
+	beginner, ok := conn.(TxBeginner)
+	if !ok {
+		return fmt.Errorf("xpgx: connection %T does not support transaction options", conn)
+	}
+	return pgx.BeginTxFunc(ctx, beginner, options.txOptions, fn)
 }
 
 // HandleError handle the Postgres errors converting for our repository errors.